@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"gin-api/services"
 	"gin-api/util"
 	"log"
@@ -21,10 +22,10 @@ func Login(c *gin.Context) {
     userData, tokenPair, err := services.Login(request)
     if err != nil {
         log.Printf("Error in Login Service: %v", err)
-        switch err {
-            case services.ErrorUserNotFound:
+        switch {
+            case errors.Is(err, services.ErrorUserNotFound):
                 c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-            case services.ErrorNotTrained:
+            case errors.Is(err, services.ErrorNotTrained), errors.Is(err, services.ErrorUserBanned), errors.Is(err, services.ErrorCredentialRevoked):
                 c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
             default:
                 c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -47,11 +48,16 @@ func RefreshToken(c *gin.Context) {
         return
     }
 
-    newAccessToken, err := util.RefreshAccessToken(refreshToken)
+    tokenPair, err := util.RefreshAccessToken(refreshToken)
     if err != nil {
         switch err {
         case util.ErrExpiredToken:
             c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token expired"})
+        case util.ErrRevokedToken:
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "session was ended, log in again"})
+        case util.ErrTokenReused:
+            c.SetCookie("refresh_token", "", -1, "/", "", false, true)
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token was already used, session ended, log in again"})
         case util.ErrInvalidToken:
             c.JSON(http.StatusBadRequest, gin.H{"error": "invalid refresh token"})
         default:
@@ -60,7 +66,53 @@ func RefreshToken(c *gin.Context) {
         return
     }
 
+    //the refresh token is rotated on every use
+    c.SetCookie("refresh_token", tokenPair.RefreshToken, 60*60*24*7, "/", "", false, true)
+
     c.JSON(http.StatusOK, gin.H{
-        "access_token": newAccessToken,
+        "access_token": tokenPair.AccessToken,
     })
+}
+
+//ends the session of the refresh token in the cookie and clears the cookie
+func Logout(c *gin.Context) {
+    refreshToken, err := c.Cookie("refresh_token")
+    if err != nil || refreshToken == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "refresh token required"})
+        return
+    }
+
+    c.SetCookie("refresh_token", "", -1, "/", "", false, true)
+    if err := services.Logout(refreshToken); err != nil {
+        switch err {
+        case util.ErrInvalidToken:
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid refresh token"})
+        default:
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        }
+        return
+    }
+
+    c.JSON(http.StatusOK, true)
+}
+
+//handles the RevokeUserSessions service. Ends every session of the user so they have to log in again.
+//requires that the userId is given at the end of the route.
+func RevokeUserSessions(c *gin.Context) {
+    id := util.GetInfoFromPath(c, "userID")
+    if id == -1 {
+        return
+    }
+
+    revoked, err := services.RevokeUserSessions(id)
+    if err != nil {
+        if errors.Is(err, services.ErrorUserNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"sessions_revoked": revoked})
 }
\ No newline at end of file
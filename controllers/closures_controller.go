@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"gin-api/models"
+	"gin-api/services"
+	"gin-api/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//handles the GetUpcomingClosures service. Returns every closure that hasn't ended yet, used by the kiosk.
+func GetUpcomingClosures(c *gin.Context) {
+	closures, err := services.GetUpcomingClosures()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, closures)
+}
+
+//handles the GetOperatingHours service. Returns the lab's weekly operating hours.
+func GetOperatingHours(c *gin.Context) {
+	hours, err := services.GetOperatingHours()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, hours)
+}
+
+//handles the CreateClosure service. Binds JSON to expected format and returns any errors encountered.
+func CreateClosure(c *gin.Context) {
+	var req services.CreateClosureRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	closure, err := services.CreateClosure(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, closure)
+}
+
+//handles the DeleteClosure service.
+//requires that the closureId is given at the end of the route.
+func DeleteClosure(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "closureID")
+	if id == -1 {
+		return
+	}
+
+	if err := services.DeleteClosure(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the SetOperatingHours service. Binds JSON to expected format and returns any errors encountered.
+func SetOperatingHours(c *gin.Context) {
+	var req []models.OperatingHours
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SetOperatingHours(req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
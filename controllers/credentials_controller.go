@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"errors"
+	"gin-api/services"
+	"gin-api/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//handles the GetUserCredentials service.
+//requires that the userId is given at the end of the route.
+func GetUserCredentials(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "userID")
+	if id == -1 {
+		return
+	}
+
+	credentials, err := services.GetUserCredentials(id)
+	if err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+//handles the AddCredential service. Binds JSON to expected format and returns any errors encountered.
+//requires that the userId is given at the end of the route.
+func AddCredential(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "userID")
+	if id == -1 {
+		return
+	}
+
+	var req services.CredentialRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	credential, err := services.AddCredential(id, req, adminId)
+	if err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credential)
+}
+
+//handles the RevokeCredential service.
+//requires that the credentialId is given at the end of the route.
+func RevokeCredential(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "credentialID")
+	if id == -1 {
+		return
+	}
+
+	adminId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	if err := services.RevokeCredential(id, adminId); err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the ReplaceCredential service. Binds JSON to expected format and returns any errors encountered.
+//requires that the credentialId is given at the end of the route.
+func ReplaceCredential(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "credentialID")
+	if id == -1 {
+		return
+	}
+
+	var req services.CredentialRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	credential, err := services.ReplaceCredential(id, req, adminId)
+	if err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credential)
+}
+
+//maps errors from the credential services to a status code
+func respondCredentialError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrorInvalidCardScan):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrorCredentialNotFound), errors.Is(err, services.ErrorUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrorCredentialInUse), errors.Is(err, services.ErrorCredentialRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
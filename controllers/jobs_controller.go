@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"gin-api/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//handles the GetPendingJobs service. Returns every scheduled job that hasn't run yet.
+func GetPendingJobs(c *gin.Context) {
+	jobs, err := services.GetPendingJobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"errors"
+	"gin-api/services"
+	"gin-api/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//handles the SetPrinterStatus service. Binds JSON to expected format and returns any errors encountered.
+//requires that the printerId is given at the end of the route. The change is recorded under the admin in the token.
+func SetPrinterStatus(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "printerID")
+	if id == -1 {
+		return
+	}
+
+	var req services.SetPrinterStatusRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	if err := services.SetPrinterStatus(id, req, adminId); err != nil {
+		respondPrinterStatusError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the SetPrinterMaintenance service. Binds JSON to expected format and returns any errors encountered.
+//requires that the printerId is given at the end of the route. The change is recorded under the admin in the token.
+func SetPrinterMaintenance(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "printerID")
+	if id == -1 {
+		return
+	}
+
+	var req services.PrinterMaintenanceRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	if err := services.SetPrinterMaintenance(id, req, adminId); err != nil {
+		respondPrinterStatusError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the ClearPrinterMaintenance service. Binds JSON to expected format and returns any errors encountered.
+//requires that the printerId is given at the end of the route. The change is recorded under the admin in the token.
+func ClearPrinterMaintenance(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "printerID")
+	if id == -1 {
+		return
+	}
+
+	var req services.PrinterMaintenanceRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	if err := services.ClearPrinterMaintenance(id, req, adminId); err != nil {
+		respondPrinterStatusError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the GetPrinterStatusHistory service. Returns every status change of a printer, newest first.
+//requires that the printerId is given at the end of the route.
+func GetPrinterStatusHistory(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "printerID")
+	if id == -1 {
+		return
+	}
+
+	history, err := services.GetPrinterStatusHistory(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+//maps errors from the printer status services to a status code
+func respondPrinterStatusError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrorPrinterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
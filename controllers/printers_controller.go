@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"gin-api/models"
 	"gin-api/services"
@@ -14,8 +15,9 @@ import (
 
 // handles the GetPrinters service. Binds JSON to expected format and returns any errors encountered.
 func GetPrinters(c *gin.Context) {
+	userId, _ := util.GetUserIdFromContext(c)
 
-	printers, err := services.GetPrinters()
+	printers, err := services.GetPrinters(userId)
 	if err != nil {
 		log.Printf("Error in GetPrinters Service: %v", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
@@ -33,7 +35,9 @@ func GetPrintersByRackId(c *gin.Context) {
 		return
 	}
 
-	printers, err := services.GetPrintersByRackId(rackId)
+	userId, _ := util.GetUserIdFromContext(c)
+
+	printers, err := services.GetPrintersByRackId(rackId, userId)
 	if err != nil {
 		log.Printf("Error in GetPrintersByRackId Service for rack %d: %v", rackId, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve printers for the specified rack"})
@@ -104,6 +108,7 @@ func UpdatePrinter(c *gin.Context) {
 }
 
 // handles the ReservePrinter service. Binds JSON to expected format and returns any errors encountered.
+// the reservation is for the user in the token unless they can manage reservations and give another user_id.
 func ReservePrinter(c *gin.Context) {
 	var req services.ReservePrinterRequest
 	if err := c.BindJSON(&req); err != nil {
@@ -111,8 +116,28 @@ func ReservePrinter(c *gin.Context) {
 		return
 	}
 
-	success, err := services.ReservePrinter(req.PrinterId, req.UserId, req.TimeMins)
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	success, err := services.ReservePrinter(req, userId, util.HasPermission(c, models.PermManageReservations))
 	if err != nil {
+		if errors.Is(err, services.ErrorPrintTimeLimit) || errors.Is(err, services.ErrorReservationTooLong) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrorExecutiveAccessRequired) || errors.Is(err, services.ErrorUserBanned) ||
+			errors.Is(err, services.ErrorPrinterClassNotAllowed) || errors.Is(err, services.ErrorNotReservationOwner) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrorPrinterBooked) || errors.Is(err, services.ErrorPrinterHeld) || errors.Is(err, services.ErrorLabClosed) ||
+			errors.Is(err, services.ErrorPrinterUnavailable) || errors.Is(err, services.ErrorReservationConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -143,6 +168,24 @@ func SetPrinterExecutive(c *gin.Context) {
 	c.JSON(http.StatusOK, true)
 }
 
+// handles the SetPrinterEgn service.
+// requires that the printerId is given at the end of the route.
+func SetPrinterEgn(c *gin.Context) {
+
+	id := util.GetInfoFromPath(c, "printerID")
+	if id == -1 {
+		return
+	}
+
+	err := services.SetPrinterEgn(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
 // handles the DeletePrinter service.
 // requires that the printerId is given at the end of the route.
 func DeletePrinter(c *gin.Context) {
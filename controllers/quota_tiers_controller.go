@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"gin-api/models"
+	"gin-api/services"
+	"gin-api/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//handles the GetQuotaTiers service. Returns every quota tier and how many users are on it.
+func GetQuotaTiers(c *gin.Context) {
+	tiers, err := services.GetQuotaTiers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tiers)
+}
+
+//handles the CreateQuotaTier service. Binds JSON to expected format and returns any errors encountered.
+func CreateQuotaTier(c *gin.Context) {
+	var req models.QuotaTier
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tier, err := services.CreateQuotaTier(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tier)
+}
+
+//handles the UpdateQuotaTier service. Binds JSON to expected format and returns any errors encountered.
+//requires that the tierId is given at the end of the route.
+func UpdateQuotaTier(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "tierID")
+	if id == -1 {
+		return
+	}
+
+	var req models.QuotaTier
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tier, err := services.UpdateQuotaTier(id, req)
+	if err != nil {
+		if errors.Is(err, services.ErrorTierNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tier)
+}
+
+//handles the DeleteQuotaTier service.
+//requires that the tierId is given at the end of the route.
+func DeleteQuotaTier(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "tierID")
+	if id == -1 {
+		return
+	}
+
+	if err := services.DeleteQuotaTier(id); err != nil {
+		switch {
+		case errors.Is(err, services.ErrorTierNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorTierInUse):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the SetUserQuotaTier service. Binds JSON to expected format and returns any errors encountered.
+//requires that the userId is given at the end of the route.
+func SetUserQuotaTier(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "userID")
+	if id == -1 {
+		return
+	}
+
+	var req services.SetUserQuotaTierRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SetUserQuotaTier(id, req); err != nil {
+		if errors.Is(err, services.ErrorTierNotFound) || errors.Is(err, services.ErrorUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"errors"
+	"gin-api/models"
 	"gin-api/services"
+	"gin-api/util"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -23,6 +26,7 @@ func GetActiveReservations(c *gin.Context) {
 }
 
 //handles the CancelActiveReservation service. Binds JSON to expected format and returns any errors encountered.
+//the reservation has to belong to the user in the token unless they can manage reservations.
 func CancelActiveReservation(c *gin.Context) {
 	var req services.CancelActiveReservationRequest
 	if err := c.BindJSON(&req); err != nil {
@@ -30,11 +34,191 @@ func CancelActiveReservation(c *gin.Context) {
 		return
 	}
 
-	_, err := services.CancelActiveReservation(req)
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	_, err := services.CancelActiveReservation(req, userId, util.HasPermission(c, models.PermManageReservations))
 	if err != nil {
+		if errors.Is(err, services.ErrorNotReservationOwner) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error:": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, true)
 }
+
+//handles the ExtendReservation service. Binds JSON to expected format and returns any errors encountered.
+//the reservation has to belong to the user in the token unless they can manage reservations.
+func ExtendReservation(c *gin.Context) {
+	var req services.ExtendReservationRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	_, err := services.ExtendReservation(req, userId, util.HasPermission(c, models.PermManageReservations))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrorNotReservationOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorPrinterBooked), errors.Is(err, services.ErrorLabClosed),
+			errors.Is(err, services.ErrorReservationConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorPrintTimeLimit), errors.Is(err, services.ErrorInsufficientMinutes),
+			errors.Is(err, services.ErrorReservationTooLong):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the BookEgnBlock service. Binds JSON to expected format and returns any errors encountered.
+//the block is booked under the admin in the token.
+func BookEgnBlock(c *gin.Context) {
+	var req services.BookEgnBlockRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	reservationIds, err := services.BookEgnBlock(req, userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrorNotEgnPrinter), errors.Is(err, services.ErrorPrinterUnavailable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorPrinterBooked):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reservation_ids": reservationIds})
+}
+
+//handles the GetEgnReservations service. Returns all active and upcoming EGN block reservations.
+func GetEgnReservations(c *gin.Context) {
+	reservations, err := services.GetEgnReservations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if reservations == nil {
+		c.JSON(http.StatusOK, []interface{}{})
+		return
+	}
+
+	c.JSON(http.StatusOK, reservations)
+}
+
+//handles the ForceEndReservation service. Binds JSON to expected format and returns any errors encountered.
+//the reservation is ended by the admin in the token, with a refund based on the reason code's refund policy.
+func ForceEndReservation(c *gin.Context) {
+	var req services.ForceEndReservationRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	result, err := services.ForceEndReservation(req, adminId)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrorUnknownReasonCode):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorReservationNotOpen):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+//handles the ReportPrintFailure service. Binds JSON to expected format and returns any errors encountered.
+//the reservation has to belong to the user in the token unless they are an admin.
+func ReportPrintFailure(c *gin.Context) {
+	var req services.ReportPrintFailureRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	refunded, err := services.ReportPrintFailure(req, userId, util.HasPermission(c, models.PermManageReservations))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrorNotReservationOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorCannotReportFailure):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"refunded_minutes": refunded})
+}
+
+//handles the CheckIn service. Binds JSON to expected format and returns any errors encountered.
+//the user is the one on the scanned card, not the one in the token, since the kiosk may be logged in as anyone.
+func CheckIn(c *gin.Context) {
+	var req services.CheckInRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reservationIds, err := services.CheckIn(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrorInvalidCardScan):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorNothingToCheckIn), errors.Is(err, services.ErrorUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorCredentialRevoked):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"checked_in": reservationIds})
+}
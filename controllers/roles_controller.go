@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"errors"
+	"gin-api/models"
+	"gin-api/services"
+	"gin-api/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//handles the GetRoles service. Returns every role, its permissions and how many users have it.
+func GetRoles(c *gin.Context) {
+	roles, err := services.GetRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles, "permissions": models.AllPermissions})
+}
+
+//handles the CreateRole service. Binds JSON to expected format and returns any errors encountered.
+func CreateRole(c *gin.Context) {
+	var req services.CreateRoleRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := services.CreateRole(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+//handles the SetRolePermissions service. Binds JSON to expected format and returns any errors encountered.
+//requires that the roleId is given at the end of the route.
+func SetRolePermissions(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "roleID")
+	if id == -1 {
+		return
+	}
+
+	var req services.SetRolePermissionsRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := services.SetRolePermissions(id, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrorRoleNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorUnknownPermission), errors.Is(err, services.ErrorBuiltInRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+//handles the DeleteRole service.
+//requires that the roleId is given at the end of the route.
+func DeleteRole(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "roleID")
+	if id == -1 {
+		return
+	}
+
+	if err := services.DeleteRole(id); err != nil {
+		switch {
+		case errors.Is(err, services.ErrorRoleNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorBuiltInRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorRoleInUse):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the SetUserRole service. Binds JSON to expected format and returns any errors encountered.
+//requires that the userId is given at the end of the route.
+func SetUserRole(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "userID")
+	if id == -1 {
+		return
+	}
+
+	var req services.SetUserRoleRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SetUserRole(id, req); err != nil {
+		switch {
+		case errors.Is(err, services.ErrorRoleNotFound), errors.Is(err, services.ErrorUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorLastAdmin):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"errors"
+	"gin-api/services"
+	"gin-api/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//handles the CreateReservationSeries service. Binds JSON to expected format and returns any errors encountered.
+//the series is booked under the admin in the token.
+func CreateReservationSeries(c *gin.Context) {
+	var req services.ReservationSeriesRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	series, err := services.CreateReservationSeries(req, userId)
+	if err != nil {
+		respondSeriesError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+//handles the GetReservationSeries service. Returns every reservation series.
+func GetReservationSeries(c *gin.Context) {
+	series, err := services.GetReservationSeries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+//handles the GetSeriesOccurrences service. Returns the running and upcoming occurrences of a series.
+//requires that the seriesId is given at the end of the route.
+func GetSeriesOccurrences(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "seriesID")
+	if id == -1 {
+		return
+	}
+
+	occurrences, err := services.GetSeriesOccurrences(id)
+	if err != nil {
+		respondSeriesError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, occurrences)
+}
+
+//handles the UpdateReservationSeries service. Binds JSON to expected format and returns any errors encountered.
+//requires that the seriesId is given at the end of the route.
+func UpdateReservationSeries(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "seriesID")
+	if id == -1 {
+		return
+	}
+
+	var req services.ReservationSeriesRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	series, err := services.UpdateReservationSeries(id, req)
+	if err != nil {
+		respondSeriesError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+//handles the CancelReservationSeries service.
+//requires that the seriesId is given at the end of the route.
+func CancelReservationSeries(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "seriesID")
+	if id == -1 {
+		return
+	}
+
+	if err := services.CancelReservationSeries(id); err != nil {
+		respondSeriesError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the UpdateSeriesOccurrence service. Binds JSON to expected format and returns any errors encountered.
+//requires that the reservationId of the occurrence is given at the end of the route.
+func UpdateSeriesOccurrence(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "reservationID")
+	if id == -1 {
+		return
+	}
+
+	var req services.UpdateSeriesOccurrenceRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.UpdateSeriesOccurrence(id, req); err != nil {
+		respondSeriesError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the CancelSeriesOccurrence service.
+//requires that the reservationId of the occurrence is given at the end of the route.
+func CancelSeriesOccurrence(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "reservationID")
+	if id == -1 {
+		return
+	}
+
+	if err := services.CancelSeriesOccurrence(id); err != nil {
+		respondSeriesError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//maps errors from the series services to a status code
+func respondSeriesError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrorSeriesNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrorPrinterBooked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
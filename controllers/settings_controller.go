@@ -3,6 +3,7 @@ package controllers
 import (
 	"gin-api/models"
 	"gin-api/services"
+	"gin-api/util"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -50,6 +51,7 @@ func GetPrinterSettings(c *gin.Context) {
 // Request body for setting printer settings
 type SetPrinterSettingsRequest struct {
 	MaxActiveReservations int `json:"max_active_reservations"`
+	CheckInMinutes        int `json:"check_in_minutes"`
 }
 
 // handles the SetPrinterSettings service. Binds JSON to expected format and returns any errors encountered.
@@ -61,7 +63,7 @@ func SetPrinterSettings(c *gin.Context) {
 		return
 	}
 
-	err := services.SetPrinterSettings(req.MaxActiveReservations)
+	err := services.SetPrinterSettings(req.MaxActiveReservations, req.CheckInMinutes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error": err.Error()})
 		return
@@ -104,3 +106,118 @@ func EjectUSB(c *gin.Context) {
 
 	c.JSON(http.StatusOK, true)
 }
+
+// handles the GetRefundPolicies service. Returns the refund policy of every force-end reason code.
+func GetRefundPolicies(c *gin.Context) {
+	policies, err := services.GetRefundPolicies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, policies)
+}
+
+// handles the SetRefundPolicy service. Binds JSON to expected format and returns any errors encountered.
+func SetRefundPolicy(c *gin.Context) {
+	var req models.RefundPolicy
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SetRefundPolicy(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+// handles the GetWeeklyResetStatus service. Returns the reset settings along with the last and next weekly reset.
+func GetWeeklyResetStatus(c *gin.Context) {
+	status, err := services.GetWeeklyResetStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// handles the GetWeeklyResetRuns service. Returns every weekly reset run, newest first.
+func GetWeeklyResetRuns(c *gin.Context) {
+	runs, err := services.GetWeeklyResetRuns()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+// handles the SetResetSettings service. Binds JSON to expected format and returns any errors encountered.
+func SetResetSettings(c *gin.Context) {
+	var req models.ResetSettings
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SetResetSettings(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+// handles the GetCardSettings service. Returns the enabled card formats along with every format available.
+func GetCardSettings(c *gin.Context) {
+	cardSettings, err := services.GetCardSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"formats": cardSettings.Formats, "available_formats": util.CardFormatNames()})
+}
+
+// handles the SetCardSettings service. Binds JSON to expected format and returns any errors encountered.
+func SetCardSettings(c *gin.Context) {
+	var req models.CardSettings
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SetCardSettings(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+// returns the id of the key tokens are signed with and every key id tokens are still accepted from
+func GetSigningKeys(c *gin.Context) {
+	active, ids, err := util.GetSigningKeyIds()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"active": active, "keys": ids})
+}
+
+// generates a new signing key for new tokens. Tokens signed with the old key keep working until they expire.
+func RotateSigningKey(c *gin.Context) {
+	kid, err := util.RotateSigningKey()
+	if err != nil {
+		if err == util.ErrKeysFromEnv {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"active": kid})
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"errors"
+	"gin-api/models"
 	"gin-api/services"
 	"gin-api/util"
 	"net/http"
@@ -16,8 +18,24 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
-	success, err := services.CreateUser(req)
+	//only staff who can manage roles can create users with more than the user role
+	if (req.Admin || (req.Role != "" && req.Role != models.RoleUser)) && !util.HasPermission(c, models.PermManageRoles) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access Denied."})
+		return
+	}
+
+	adminId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	success, err := services.CreateUser(req, adminId)
 	if err != nil {
+		if errors.Is(err, services.ErrorCredentialInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error": err.Error()})
 		return
 	}
@@ -99,15 +117,18 @@ func GetUserById(c *gin.Context) {
 		return
 	}
 
-	parsedData, err := util.ParseScannerString(req.ScannerMessage)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scanner message"})
-		return
-	}
-
-	userData, err := services.GetUserById(parsedData.Id)
+	userData, err := services.GetUserByCard(req.ScannerMessage)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		switch {
+		case errors.Is(err, services.ErrorInvalidCardScan):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scanner message"})
+		case errors.Is(err, services.ErrorUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrorCredentialRevoked):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
@@ -132,6 +153,24 @@ func SetUserExecutiveAccess(c *gin.Context) {
 	c.JSON(http.StatusOK, true)
 }
 
+//handles the SetUserEgnLab service. Binds JSON to expected format and returns any errors encountered.
+//requires that the userId is given at the end of the route.
+func SetUserEgnLab(c *gin.Context) {
+
+	id := util.GetInfoFromPath(c, "userID")
+	if id == -1 {
+		return
+	}
+
+	err := services.SetUserEgnLab(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error:": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
 //handles the AddUserWeeklyMinutes service. Binds JSON to expected format and returns any errors encountered.
 //requires that the userId is given at the end of the route.
 func AddUserWeeklyMinutes(c *gin.Context) {
@@ -147,7 +186,9 @@ func AddUserWeeklyMinutes(c *gin.Context) {
 		return
 	}
 
-	err := services.AddUserWeeklyMinutes(id, req)
+	adminId, _ := util.GetUserIdFromContext(c)
+
+	err := services.AddUserWeeklyMinutes(id, req, adminId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Internal server error:": err.Error()})
 		return
@@ -196,3 +237,59 @@ func GetUserWeeklyMinutes(c *gin.Context) {
 
 	c.JSON(http.StatusOK, minutes)
 }
+
+//handles the GetMinuteStatement service for the user in the token.
+func GetMyMinuteStatement(c *gin.Context) {
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	statement, err := services.GetMinuteStatement(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, statement)
+}
+
+//handles the GetMinuteStatement service for any user.
+//requires that the userId is given at the end of the route.
+func GetUserMinuteStatement(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "userID")
+	if id == -1 {
+		return
+	}
+
+	statement, err := services.GetMinuteStatement(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, statement)
+}
+
+//handles the GetMinuteDiscrepancies service.
+func GetMinuteDiscrepancies(c *gin.Context) {
+	discrepancies, err := services.GetMinuteDiscrepancies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, discrepancies)
+}
+
+//handles the ReconcileMinutes service. Returns the users whose balances were corrected.
+func ReconcileMinutes(c *gin.Context) {
+	corrected, err := services.ReconcileMinutes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, corrected)
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"gin-api/models"
+	"gin-api/services"
+	"gin-api/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//handles the JoinWaitlist service. Binds JSON to expected format and returns any errors encountered.
+//the user joining is the user in the token.
+func JoinWaitlist(c *gin.Context) {
+	var req services.JoinWaitlistRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	entry, err := services.JoinWaitlist(req, userId)
+	if err != nil {
+		if err == services.ErrorExecutiveAccessRequired || err == services.ErrorPrinterClassNotAllowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+//handles the LeaveWaitlist service. requires that the entryId is given at the end of the route.
+func LeaveWaitlist(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "entryID")
+	if id == -1 {
+		return
+	}
+
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	_, err := services.LeaveWaitlist(id, userId, util.HasPermission(c, models.PermManageReservations))
+	if err != nil {
+		if err == services.ErrorNotWaitlistOwner {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, true)
+}
+
+//handles the GetWaitlist service. Returns every open waitlist entry with its position in line.
+func GetWaitlist(c *gin.Context) {
+	entries, err := services.GetWaitlist()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+//handles the GetPrinterWaitlist service. requires that the printerId is given at the end of the route.
+func GetPrinterWaitlist(c *gin.Context) {
+	id := util.GetInfoFromPath(c, "printerID")
+	if id == -1 {
+		return
+	}
+
+	entries, err := services.GetPrinterWaitlist(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+//handles the GetUserWaitlist service. Returns the waitlist entries of the user in the token.
+func GetMyWaitlist(c *gin.Context) {
+	userId, ok := util.GetUserIdFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	entries, err := services.GetUserWaitlist(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
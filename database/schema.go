@@ -0,0 +1,376 @@
+package database
+
+import (
+	"fmt"
+	"log"
+)
+
+//a column that was added to an existing table after the original database was created
+type schemaColumn struct {
+	table      string
+	name       string
+	definition string
+}
+
+//columns the API expects on top of the original tables. SQLite has no "ADD COLUMN IF NOT EXISTS",
+//so each one is checked against PRAGMA table_info before being added.
+var schemaColumns = []schemaColumn{
+	{"reservations", "is_scheduled", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"settings", "timezone", "TEXT NOT NULL DEFAULT 'America/New_York'"},
+	{"reservations", "series_id", "INTEGER DEFAULT NULL REFERENCES reservation_series(id)"},
+	{"printers", "status", "TEXT NOT NULL DEFAULT 'available'"},
+	{"reservations", "end_reason", "TEXT DEFAULT NULL"},
+	{"reservations", "refunded_minutes", "INTEGER NOT NULL DEFAULT 0"},
+	{"reservations", "ended_by", "INTEGER DEFAULT NULL REFERENCES users(id)"},
+	{"reservations", "ended_at", "DATETIME DEFAULT NULL"},
+	{"reservations", "outcome", "TEXT DEFAULT NULL"},
+	{"settings", "check_in_minutes", "INTEGER NOT NULL DEFAULT 0"},
+	{"reservations", "checked_in_at", "DATETIME DEFAULT NULL"},
+	{"users", "no_show_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"settings", "reset_weekday", "INTEGER NOT NULL DEFAULT 1"},
+	{"settings", "reset_time", "TEXT NOT NULL DEFAULT '08:00'"},
+	{"settings", "rollover_cap_minutes", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "quota_tier_id", "INTEGER DEFAULT NULL REFERENCES quota_tiers(id)"},
+	{"users", "role_id", "INTEGER DEFAULT NULL REFERENCES roles(id)"},
+	{"settings", "card_formats", "TEXT NOT NULL DEFAULT 'track1,track2,barcode,nfc_uid'"},
+}
+
+//tables the API expects. Every statement must be safe to run against an already up to date database.
+var schemaTables = []string{
+	//the original tables, as they were before schemaColumns. Existing databases already have them, a new one
+	//(such as one made for tests) gets them here.
+	`CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		has_training BOOLEAN DEFAULT FALSE,
+		admin BOOLEAN DEFAULT FALSE,
+		has_executive_access BOOLEAN NOT NULL DEFAULT 0,
+		is_egn_lab BOOLEAN NOT NULL DEFAULT 0,
+		weekly_minutes INTEGER NOT NULL DEFAULT 1800,
+		ban_time_end DATETIME DEFAULT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS printers (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		color TEXT NOT NULL,
+		rack INTEGER NOT NULL,
+		in_use BOOLEAN NOT NULL DEFAULT FALSE,
+		last_reserved_by TEXT,
+		is_executive BOOLEAN NOT NULL DEFAULT FALSE,
+		is_egn_printer BOOLEAN NOT NULL DEFAULT 0,
+		rack_position INTEGER
+	)`,
+	`CREATE TABLE IF NOT EXISTS reservations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		printerid INTEGER NOT NULL,
+		time_reserved DATETIME NOT NULL,
+		time_complete DATETIME,
+		userId INTEGER NOT NULL,
+		is_active BOOLEAN DEFAULT 1,
+		is_egn_reservation BOOLEAN NOT NULL DEFAULT 0,
+		FOREIGN KEY (printerid) REFERENCES printers(id),
+		FOREIGN KEY (userId) REFERENCES users(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS settings (
+		name TEXT UNIQUE,
+		day_max_print_hours_week INTEGER DEFAULT 0,
+		night_max_print_hours_week INTEGER DEFAULT 0,
+		day_max_print_hours_weekend INTEGER DEFAULT 0,
+		night_max_print_hours_weekend INTEGER DEFAULT 0,
+		day_start TEXT DEFAULT '0:00',
+		night_start TEXT DEFAULT '0:00',
+		default_user_weekly_hours INTEGER DEFAULT 0,
+		last_ran_date DATETIME DEFAULT '2000-01-01',
+		max_active_reservations INTEGER NOT NULL DEFAULT 2
+	)`,
+	//the settings are read from the default row, a new database starts with one
+	`INSERT OR IGNORE INTO settings (name, day_start, night_start, default_user_weekly_hours) VALUES ('default', '08:00', '20:00', 30)`,
+	`CREATE TABLE IF NOT EXISTS scheduled_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_type TEXT NOT NULL,
+		reference_id INTEGER NOT NULL DEFAULT 0,
+		run_at DATETIME NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		completed_at DATETIME
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_scheduled_jobs_status ON scheduled_jobs (status, job_type, reference_id)`,
+	//a job type has at most one pending job per reference. Duplicates that concurrent scheduling could add before
+	//this index existed are cancelled, keeping the oldest.
+	`UPDATE scheduled_jobs SET status = 'cancelled' WHERE status = 'pending' AND id NOT IN (
+		SELECT MIN(id) FROM scheduled_jobs WHERE status = 'pending' GROUP BY job_type, reference_id)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_scheduled_jobs_pending ON scheduled_jobs (job_type, reference_id) WHERE status = 'pending'`,
+	`CREATE TABLE IF NOT EXISTS waitlist (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		printer_id INTEGER,
+		color TEXT,
+		status TEXT NOT NULL DEFAULT 'waiting',
+		offered_printer_id INTEGER,
+		offer_expires_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS reservation_series (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		printer_ids TEXT NOT NULL,
+		weekday INTEGER NOT NULL,
+		start_time TEXT NOT NULL,
+		time_mins INTEGER NOT NULL,
+		start_date TEXT NOT NULL,
+		end_date TEXT NOT NULL,
+		exception_dates TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'active',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS closures (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS operating_hours (
+		weekday INTEGER PRIMARY KEY,
+		open_time TEXT NOT NULL DEFAULT '00:00',
+		close_time TEXT NOT NULL DEFAULT '24:00',
+		is_closed BOOLEAN NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS printer_status_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		printer_id INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		previous_status TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		changed_by INTEGER,
+		changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (printer_id) REFERENCES printers(id),
+		FOREIGN KEY (changed_by) REFERENCES users(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS refund_policies (
+		reason_code TEXT PRIMARY KEY,
+		refund_type TEXT NOT NULL,
+		fixed_minutes INTEGER NOT NULL DEFAULT 0,
+		description TEXT NOT NULL DEFAULT ''
+	)`,
+	//default reason codes, admins can change their refunds (or add more) from the settings routes
+	`INSERT OR IGNORE INTO refund_policies (reason_code, refund_type, fixed_minutes, description) VALUES
+		('misuse', 'none', 0, 'Reservation ended for misuse of the printer'),
+		('printer_failure', 'full', 0, 'The printer failed during the reservation'),
+		('admin_request', 'remaining', 0, 'Ended by lab staff for any other reason'),
+		('print_failed', 'none', 0, 'Reported as a failed print by the user'),
+		('no_show', 'remaining', 0, 'Released because the user never checked in at the kiosk')`,
+	`CREATE TABLE IF NOT EXISTS minute_ledger (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		delta INTEGER NOT NULL,
+		balance_after INTEGER NOT NULL,
+		entry_type TEXT NOT NULL,
+		reservation_id INTEGER,
+		actor_id INTEGER,
+		note TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (reservation_id) REFERENCES reservations(id),
+		FOREIGN KEY (actor_id) REFERENCES users(id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_minute_ledger_user ON minute_ledger (user_id)`,
+	`CREATE TABLE IF NOT EXISTS quota_tiers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		weekly_hours INTEGER NOT NULL,
+		max_active_reservations INTEGER NOT NULL,
+		max_reservation_minutes INTEGER NOT NULL DEFAULT 0,
+		printer_classes TEXT NOT NULL DEFAULT 'standard'
+	)`,
+	`CREATE TABLE IF NOT EXISTS weekly_reset_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		period_start DATETIME NOT NULL,
+		ran_at DATETIME NOT NULL,
+		triggered_by TEXT NOT NULL,
+		weekly_minutes INTEGER NOT NULL,
+		rollover_cap_minutes INTEGER NOT NULL,
+		users_reset INTEGER NOT NULL,
+		minutes_rolled_over INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS roles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS role_permissions (
+		role_id INTEGER NOT NULL,
+		permission TEXT NOT NULL,
+		PRIMARY KEY (role_id, permission),
+		FOREIGN KEY (role_id) REFERENCES roles(id)
+	)`,
+	//default roles. The admin role is given every permission in code, so it has no rows in role_permissions.
+	`INSERT OR IGNORE INTO roles (id, name, description) VALUES
+		(1, 'user', 'Can reserve printers and manage their own reservations'),
+		(2, 'lab_assistant', 'Lab staff who train users, handle reservations and printer maintenance'),
+		(3, 'admin', 'Full access to every part of the API')`,
+	`INSERT OR IGNORE INTO role_permissions (role_id, permission)
+		SELECT id, p.permission FROM roles, (SELECT 'users.train' AS permission UNION SELECT 'printers.maintenance' UNION SELECT 'reservations.manage') p
+		WHERE name = 'lab_assistant' AND NOT EXISTS (SELECT 1 FROM role_permissions WHERE role_id = roles.id)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token_id TEXT NOT NULL UNIQUE,
+		family_id TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		issued_at DATETIME NOT NULL,
+		expires_at INTEGER NOT NULL,
+		used_at DATETIME,
+		revoked_at DATETIME,
+		revoke_reason TEXT,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens (user_id)`,
+	`CREATE TABLE IF NOT EXISTS credentials (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		key_type TEXT NOT NULL,
+		credential_key TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		created_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		revoked_by INTEGER NOT NULL DEFAULT 0,
+		revoked_at DATETIME,
+		replaced_by INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	)`,
+	//a card can only belong to one user at a time, revoked credentials don't count
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_credentials_active_key ON credentials (key_type, credential_key) WHERE revoked_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_credentials_user ON credentials (user_id)`,
+	`CREATE TABLE IF NOT EXISTS schema_migrations (
+		name TEXT PRIMARY KEY,
+		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+}
+
+//statements that fill in columns from schemaColumns for existing rows. They run after the columns are added
+//and must also be safe to run against an already up to date database.
+var schemaMigrations = []string{
+	//users from before roles get the role matching their admin flag
+	`UPDATE users SET role_id = (SELECT id FROM roles WHERE name = CASE WHEN users.admin THEN 'admin' ELSE 'user' END)
+		WHERE role_id IS NULL`,
+}
+
+//a change to existing data that must only ever run once, because running it again would also change rows
+//added after it. Each is recorded in schema_migrations by name once it has run.
+type oneTimeMigration struct {
+	name      string
+	statement string
+}
+
+//one-time migrations, run in order after schemaMigrations
+var oneTimeMigrations = []oneTimeMigration{
+	//users from before the ledger start theirs with the balance they already had
+	{"minute_ledger_opening_balances", `INSERT INTO minute_ledger (user_id, delta, balance_after, entry_type, note)
+		SELECT id, weekly_minutes, weekly_minutes, 'opening', 'balance before the ledger was kept' FROM users
+		WHERE NOT EXISTS (SELECT 1 FROM minute_ledger WHERE user_id = users.id)`},
+	//users from before credentials were kept had the card number as their id, that card becomes their first
+	//credential. Users created since get their ids from the database, so this must never run for them.
+	{"credentials_from_card_number_ids", `INSERT OR IGNORE INTO credentials (user_id, key_type, credential_key, label)
+		SELECT id, 'card_number', CAST(id AS TEXT), 'card' FROM users
+		WHERE NOT EXISTS (SELECT 1 FROM credentials WHERE user_id = users.id)`},
+}
+
+//runs on startup (in main.go). Brings an existing database up to the schema the API expects by creating
+//missing tables, adding missing columns, filling those columns in and running any one-time migrations that
+//haven't run yet. Existing data is never dropped.
+func EnsureSchema() error {
+	for _, statement := range schemaTables {
+		if _, err := DB.Exec(statement); err != nil {
+			return fmt.Errorf("error creating table: %v", err)
+		}
+	}
+
+	for _, column := range schemaColumns {
+		exists, err := columnExists(column.table, column.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		alterSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", column.table, column.name, column.definition)
+		if _, err := DB.Exec(alterSQL); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %v", column.table, column.name, err)
+		}
+		log.Printf("Added column %s.%s to the database", column.table, column.name)
+	}
+
+	for _, statement := range schemaMigrations {
+		if _, err := DB.Exec(statement); err != nil {
+			return fmt.Errorf("error migrating existing rows: %v", err)
+		}
+	}
+
+	for _, migration := range oneTimeMigrations {
+		if err := runOnce(migration); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//given a one-time migration, run it unless schema_migrations says it already ran. The migration and its
+//record are committed together, so it can't run twice or be recorded without running.
+func runOnce(migration oneTimeMigration) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting migration %s: %v", migration.name, err)
+	}
+
+	result, err := tx.Exec("INSERT OR IGNORE INTO schema_migrations (name) VALUES (?)", migration.name)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error recording migration %s: %v", migration.name, err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		tx.Rollback() //already ran
+		return nil
+	}
+
+	result, err = tx.Exec(migration.statement)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error running migration %s: %v", migration.name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration %s: %v", migration.name, err)
+	}
+	rows, _ := result.RowsAffected()
+	log.Printf("Ran migration %s on %d row(s)", migration.name, rows)
+	return nil
+}
+
+//given a table and column name, return whether that column exists on the table
+func columnExists(table string, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, fmt.Errorf("error scanning columns of %s: %v", table, err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
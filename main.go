@@ -5,6 +5,8 @@ import (
 	"gin-api/database"
 	"gin-api/recovery"
 	"gin-api/routes"
+	"gin-api/scheduler"
+	"gin-api/services"
 	"gin-api/util"
 	"log"
 
@@ -19,7 +21,10 @@ import (
 
 func main() {
 	log.Println("Starting the application...")
-	db, err := sql.Open("sqlite3", "./test.db")
+	//transactions take the write lock when they begin and wait for each other instead of failing, so
+	//reservation claims from two kiosks at once run one after the other. ReservePrinter's check for overlapping
+	//bookings inside its transaction depends on this.
+	db, err := sql.Open("sqlite3", "./test.db?_txlock=immediate&_busy_timeout=5000")
 	if err != nil {
 		log.Printf("Failed to open database: %v", err)
 	}
@@ -28,13 +33,17 @@ func main() {
 	database.SetDB(db)
 	log.Println("Database connection established.")
 
-	//complete reservations that ended while the API was offline
-	_, err = recovery.CompleteMissedReservations()
-	if err != nil {
-		log.Printf("Failed to complete missed reservations: %v", err)
+	//tokens can't be signed or checked without the keys, generated on first boot if there aren't any
+	if err := util.LoadSigningKeys(); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
+	//add any tables or columns that are missing from an older database file
+	if err := database.EnsureSchema(); err != nil {
+		log.Printf("Failed to update database schema: %v", err)
 	}
 
-	//Initialize hardware host
+	//Initialize hardware host before recovery, which needs the GPIO pins
 	if state, err := host.Init(); err != nil {
 		log.Printf("Failed to initialize periph: %v", err)
 	} else {
@@ -42,6 +51,32 @@ func main() {
 		fmt.Printf("Initialization State: The following drivers were: %+v\n", state)
 	}
 
+	//handlers have to be registered before recovery, which schedules reservation jobs
+	services.RegisterJobHandlers()
+
+	//complete reservations that ended while the API was offline
+	report, err := recovery.CompleteMissedReservations()
+	if err != nil {
+		log.Printf("Failed to complete missed reservations: %v", err)
+	}
+	log.Printf("Reservation recovery: %s", report)
+	for _, failure := range report.Failed {
+		log.Printf("Reservation recovery failed for %s", failure)
+	}
+
+	//run jobs that came due while the API was offline and start timers for the rest
+	if err := scheduler.Start(); err != nil {
+		log.Printf("Failed to start job scheduler: %v", err)
+	}
+	if err := services.ScheduleWeeklyReset(); err != nil {
+		log.Printf("Failed to schedule weekly reset: %v", err)
+	}
+	if cleared, err := services.ClearExpiredBans(); err != nil {
+		log.Printf("Failed to clear expired bans: %v", err)
+	} else if cleared > 0 {
+		log.Printf("Cleared %d expired ban(s)", cleared)
+	}
+
 	r := gin.New()
 
 	// CORS middleware setup before routes
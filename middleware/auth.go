@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"gin-api/models"
 	"gin-api/util"
 	"net/http"
 	"strconv"
@@ -29,22 +30,34 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
+		//refresh tokens can only be used to get a new access token, not to call the API
+		if !ok || !token.Valid || claims["type"] != "access" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 			c.Abort()
 			return
 		}
 
+		//JSON arrays in the claims are decoded as []interface{}
+		permissions := []string{}
+		if list, ok := claims["permissions"].([]interface{}); ok {
+			for _, p := range list {
+				if permission, ok := p.(string); ok {
+					permissions = append(permissions, permission)
+				}
+			}
+		}
+
 		c.Set("userId", claims["userId"])
-		c.Set("isAdmin", claims["isAdmin"])
+		c.Set("role", claims["role"])
+		c.Set("permissions", permissions)
 		c.Next()
 	}
 }
 
-func AdminPermission() gin.HandlerFunc {
+// only lets the request through if the token's role grants the given permission
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		isAdmin, exists := c.Get("isAdmin")
-		if !exists || !isAdmin.(bool) {
+		if !util.HasPermission(c, permission) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Access Denied."})
 			c.Abort()
 			return
@@ -53,10 +66,12 @@ func AdminPermission() gin.HandlerFunc {
 	}
 }
 
+// only lets the request through if the userID in the path is the user in the token, or the token's role can
+// manage users or reservations
 func UserOwnershipPermission() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the requesting user's ID from the JWT token
-		requestingUserID := c.GetInt("userId")
+		requestingUserID, _ := util.GetUserIdFromContext(c)
 		// Get the target user ID from URL parameter
 		targetUserID, err := strconv.Atoi(c.Param("userID"))
 
@@ -66,9 +81,9 @@ func UserOwnershipPermission() gin.HandlerFunc {
 			return
 		}
 
-		// Allow if user is admin or requesting their own data
-		isAdmin := c.GetBool("isAdmin")
-		if !isAdmin && requestingUserID != targetUserID {
+		// Allow if user can manage users (or their reservations) or is requesting their own data
+		canManage := util.HasPermission(c, models.PermManageUsers) || util.HasPermission(c, models.PermManageReservations)
+		if !canManage && requestingUserID != targetUserID {
 			c.JSON(403, gin.H{"error": "Unauthorized access"})
 			c.Abort()
 			return
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+//a blackout period (holiday, finals cleanup, maintenance day) when no printer can be reserved
+type Closure struct {
+	Id         int       `json:"id"`
+	Starts_At  time.Time `json:"starts_at"`
+	Ends_At    time.Time `json:"ends_at"`
+	Reason     string    `json:"reason"`
+	Created_At time.Time `json:"created_at"`
+}
+
+//the lab's regular hours for one day of the week. Days without a row are open all day.
+type OperatingHours struct {
+	Weekday    int    `json:"weekday"`    //0 is Sunday, 6 is Saturday
+	Open_Time  string `json:"open_time"`  //"HH:MM" in the lab's timezone
+	Close_Time string `json:"close_time"` //"HH:MM" in the lab's timezone, "24:00" for midnight at the end of the day
+	Is_Closed  bool   `json:"is_closed"`  //closed the whole day, open and close times are ignored
+}
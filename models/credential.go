@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+//a card or tag a user logs in with. A user can have several, and a lost or replaced card is revoked rather
+//than deleted so the user keeps their account and history.
+type Credential struct {
+	Id          int        `json:"id"`
+	User_Id     int        `json:"user_id"`
+	Key_Type    string     `json:"key_type"`    //card_number or nfc_uid
+	Key         string     `json:"key"`         //the card number or tag UID as the scanner reads it
+	Label       string     `json:"label"`       //what the credential is, e.g. student card or key fob
+	Created_By  int        `json:"created_by"`  //admin who added it, 0 for credentials the API added itself
+	Created_At  time.Time  `json:"created_at"`
+	Revoked_By  int        `json:"revoked_by"`  //admin who revoked it, 0 if it is active
+	Revoked_At  *time.Time `json:"revoked_at"`
+	Replaced_By int        `json:"replaced_by"` //the credential that replaced it, 0 if it wasn't replaced
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+//a unit of deferred work stored in the scheduled_jobs table, e.g. completing a reservation at its end time
+type Job struct {
+	Id          int       `json:"id"`
+	Type        string    `json:"type"`
+	ReferenceId int       `json:"reference_id"` //id of whatever the job acts on (a reservation id for reservation jobs, 0 if unused)
+	RunAt       time.Time `json:"run_at"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	CreatedAt   time.Time `json:"created_at"`
+}
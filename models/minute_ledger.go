@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+//one change to a user's weekly minutes. A user's balance is the sum of their entries' deltas.
+type MinuteLedgerEntry struct {
+	Id             int       `json:"id"`
+	User_Id        int       `json:"user_id"`
+	Delta          int       `json:"delta"`          //minutes added (positive) or taken (negative)
+	Balance_After  int       `json:"balance_after"`
+	Entry_Type     string    `json:"entry_type"`     //debit, refund, grant, weekly_reset, opening
+	Reservation_Id int       `json:"reservation_id"` //reservation the entry is for, 0 if none
+	Actor_Id       int       `json:"actor_id"`       //user who caused the entry, 0 for the API itself (jobs, resets, rollbacks)
+	Note           string    `json:"note"`
+	Created_At     time.Time `json:"created_at"`
+}
+
+//a user's current balance along with the ledger entries that explain it, newest first
+type MinuteStatement struct {
+	User_Id        int                 `json:"user_id"`
+	Weekly_Minutes int                 `json:"weekly_minutes"`
+	Ledger_Balance int                 `json:"ledger_balance"` //sum of every entry, differs from Weekly_Minutes only if the balance was changed outside the ledger
+	Entries        []MinuteLedgerEntry `json:"entries"`
+}
+
+//a user whose weekly_minutes don't match the sum of their ledger entries
+type MinuteDiscrepancy struct {
+	User_Id        int    `json:"user_id"`
+	Username       string `json:"username"`
+	Weekly_Minutes int    `json:"weekly_minutes"`
+	Ledger_Balance int    `json:"ledger_balance"`
+}
@@ -7,6 +7,11 @@ type Printer struct {
 	Rack             int    `json:"rack"`
 	Rack_Position    int    `json:"rack_position"`
 	In_Use           bool   `json:"in_use"`
+	Status           string `json:"status"` // available, reserved, maintenance, offline or retired
 	Last_Reserved_By string `json:"last_reserved_by"`
 	Is_Executive     bool   `json:"is_executive"`
+	Is_Egn_Printer   bool   `json:"is_egn_printer"`
+	Can_Reserve      bool   `json:"can_reserve"` // whether the requesting user is allowed to reserve this printer
+
+	Upcoming_Reservations []UpcomingReservation `json:"upcoming_reservations"`
 }
\ No newline at end of file
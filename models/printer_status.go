@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+//one change of a printer's status, kept as the printer's status history
+type PrinterStatusChange struct {
+	Id                  int       `json:"id"`
+	PrinterId           int       `json:"printer_id"`
+	Status              string    `json:"status"`
+	Previous_Status     string    `json:"previous_status"`
+	Reason              string    `json:"reason"`
+	Changed_By          int       `json:"changed_by"` //id of the admin who made the change, 0 for changes made by reservations starting and ending
+	Changed_By_Username string    `json:"changed_by_username"`
+	Changed_At          time.Time `json:"changed_at"`
+}
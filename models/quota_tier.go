@@ -0,0 +1,13 @@
+package models
+
+//a named allowance that can be assigned to a group of users, e.g. staff or first-year students.
+//Users without a tier get the default weekly hours and max active reservations from the settings.
+type QuotaTier struct {
+	Id                      int      `json:"id"`
+	Name                    string   `json:"name"`
+	Weekly_Hours            int      `json:"weekly_hours"`            //weekly minutes are reset to this many hours
+	Max_Active_Reservations int      `json:"max_active_reservations"` //active and upcoming reservations a user can hold at once
+	Max_Reservation_Minutes int      `json:"max_reservation_minutes"` //longest single reservation, 0 for no limit beyond the print hours
+	Printer_Classes         []string `json:"printer_classes"`         //classes of printer the tier can reserve, standard and/or executive
+	User_Count              int      `json:"user_count"`              //users assigned the tier, filled in when listing tiers
+}
@@ -0,0 +1,9 @@
+package models
+
+//how much of a reservation is refunded when an admin force-ends it for a given reason
+type RefundPolicy struct {
+	Reason_Code   string `json:"reason_code"`
+	Refund_Type   string `json:"refund_type"`   //none, remaining, full or fixed
+	Fixed_Minutes int    `json:"fixed_minutes"` //minutes refunded when Refund_Type is fixed, capped at the reservation's length
+	Description   string `json:"description"`
+}
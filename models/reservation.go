@@ -12,7 +12,19 @@ type Reservation struct {
 	Time_Reserved time.Time `json:"time_reserved"`
 	Time_Complete time.Time `json:"time_complete"`
 	Is_Active bool `json:"is_active"`
-	Timer *time.Timer
+	Is_Scheduled bool `json:"is_scheduled"`
+	Is_Egn_Reservation bool `json:"is_egn_reservation"` // part of an EGN course block booking, not charged to weekly_minutes
+	Series_Id int `json:"series_id"` // recurring series this is an occurrence of, 0 if none. Not charged to weekly_minutes either
+	JobId int `json:"-"` // scheduled job that completes the reservation (or starts it, for upcoming reservations)
+}
+
+//a future booking on a printer, shown alongside the printer in GetPrinters
+type UpcomingReservation struct {
+	Id            int       `json:"id"`
+	UserId        int       `json:"user_id"`
+	Username      string    `json:"username"`
+	Time_Reserved time.Time `json:"time_reserved"`
+	Time_Complete time.Time `json:"time_complete"`
 }
 
 type ReservationManager struct {
@@ -29,4 +41,9 @@ type ReservationDTO struct {
 	Time_Reserved      time.Time `json:"time_reserved"`
 	Time_Complete      time.Time `json:"time_complete"`
 	Is_Active          bool      `json:"is_active"`
+	Is_Scheduled       bool      `json:"is_scheduled"`
+	Is_Egn_Reservation bool      `json:"is_egn_reservation"`
+	Outcome            string    `json:"outcome"`    // success, failed, cancelled or aborted. Empty until it ends
+	End_Reason         string    `json:"end_reason"` // completed, cancelled, print_failed, or the reason code it was force-ended with
+	Refunded_Minutes   int       `json:"refunded_minutes"`
 }
\ No newline at end of file
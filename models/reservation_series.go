@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+//a recurring booking, e.g. "every Tuesday 10-12 on printers 5-8 for the semester". Every occurrence is stored
+//as its own reservation (with series_id set) so it starts and completes like any other reservation.
+type ReservationSeries struct {
+	Id              int       `json:"id"`
+	UserId          int       `json:"user_id"`
+	PrinterIds      []int     `json:"printer_ids"`
+	Weekday         int       `json:"weekday"`    //0 is Sunday, 6 is Saturday
+	Start_Time      string    `json:"start_time"` //"HH:MM" in the lab's timezone
+	Time_Mins       int       `json:"time_mins"`
+	Start_Date      string    `json:"start_date"` //"YYYY-MM-DD", first day the series can run
+	End_Date        string    `json:"end_date"`   //"YYYY-MM-DD", last day the series can run
+	Exception_Dates []string  `json:"exception_dates"`
+	Status          string    `json:"status"`
+	Created_At      time.Time `json:"created_at"`
+}
@@ -0,0 +1,38 @@
+package models
+
+//permissions a role can grant. Every admin route group requires one of these (see routes.SetupRouter).
+const (
+	PermManageUsers        = "users.manage"         //create users, set their access, minutes, bans and quota tiers
+	PermTrainUsers         = "users.train"          //mark users as trained
+	PermManagePrinters     = "printers.manage"      //add, edit and remove printers
+	PermPrinterMaintenance = "printers.maintenance" //set printer status and maintenance
+	PermManageReservations = "reservations.manage"  //cancel, extend, end and report failures on anyone's reservations
+	PermBookBlocks         = "reservations.block"   //book EGN blocks and recurring series
+	PermManageSettings     = "settings.manage"      //change settings, refund policies, the weekly reset and quota tiers
+	PermManageData         = "data.manage"          //import and export the database
+	PermManageClosures     = "closures.manage"      //add closures and set operating hours
+	PermViewJobs           = "jobs.view"            //see the scheduler's pending jobs
+	PermManageRoles        = "roles.manage"         //create roles, change their permissions and assign them to users
+)
+
+//every permission, in the order they are listed to admins
+var AllPermissions = []string{
+	PermManageUsers, PermTrainUsers, PermManagePrinters, PermPrinterMaintenance, PermManageReservations,
+	PermBookBlocks, PermManageSettings, PermManageData, PermManageClosures, PermViewJobs, PermManageRoles,
+}
+
+//the roles every database starts with. The admin role always has every permission.
+const (
+	RoleUser         = "user"
+	RoleLabAssistant = "lab_assistant"
+	RoleAdmin        = "admin"
+)
+
+//a named set of permissions that users are assigned to
+type Role struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	User_Count  int      `json:"user_count"`
+}
@@ -4,6 +4,8 @@ package models
 type Settings struct {
 	TimeSettings		TimeSettings `json:"time_settings"`
 	PrinterSettings		PrinterSettings `json:"printer_settings"`
+	ResetSettings		ResetSettings `json:"reset_settings"`
+	CardSettings		CardSettings `json:"card_settings"`
 }
 
 //time settings struct
@@ -13,6 +15,7 @@ type TimeSettings struct {
 	DayStart               string    `json:"day_start"`
 	NightStart             string    `json:"night_start"`
 	DefaultUserWeeklyHours int       `json:"default_user_weekly_hours"`
+	Timezone               string    `json:"timezone"` // IANA name of the lab's timezone, day/night windows are evaluated in it
 	UpToDate               bool      `json:"up_to_date"`
 }
 
@@ -25,5 +28,20 @@ type PrintTime struct {
 //printer settings struct
 type PrinterSettings struct {
 	MaxActiveReservations int 	`json:"max_active_reservations"`
+	CheckInMinutes        int 	`json:"check_in_minutes"` // minutes a user has to check in after their reservation starts, 0 turns check-in off
 	UpToDate              bool	`json:"up_to_date"`
 }
+
+//weekly minutes reset settings struct
+type ResetSettings struct {
+	ResetWeekday       int    `json:"reset_weekday"`        // day of the week minutes are reset on, 0 (Sunday) to 6 (Saturday)
+	ResetTime          string `json:"reset_time"`           // lab time the reset runs at, HH:MM
+	RolloverCapMinutes int    `json:"rollover_cap_minutes"` // unused minutes carried into the next week, up to this many. 0 turns rollover off
+	UpToDate           bool   `json:"up_to_date"`
+}
+
+//card reader settings struct
+type CardSettings struct {
+	Formats  []string `json:"formats"` // card formats scans are read as, tried in this order
+	UpToDate bool     `json:"up_to_date"`
+}
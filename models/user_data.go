@@ -11,8 +11,13 @@ type UserData struct {
 	Trained              bool         `json:"trained"`
 	Admin                bool         `json:"admin"`
 	Has_Executive_Access bool         `json:"has_executive_access"`
+	Is_Egn_Lab           bool         `json:"is_egn_lab"`
 	Ban_Time_End         sql.NullTime `json:"-"`
 	Weekly_Minutes       int          `json:"weekly_minutes"`
+	No_Show_Count        int          `json:"no_show_count"`
+	Quota_Tier_Id        int          `json:"quota_tier_id"` // 0 for the default quota from the settings
+	Role                 string       `json:"role"`
+	Permissions          []string     `json:"permissions,omitempty"` // only filled in at login
 }
 
 func (u UserData) MarshalJSON() ([]byte, error) {
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+//a user's place in line for a printer. Either PrinterId is set (waiting for that printer) or Color is set
+//(waiting for any printer of that color). When a matching printer frees up the entry is offered that printer
+//until Offer_Expires_At.
+type WaitlistEntry struct {
+	Id                 int        `json:"id"`
+	UserId             int        `json:"user_id"`
+	Username           string     `json:"username"`
+	PrinterId          int        `json:"printer_id"` //0 when waiting for any printer of Color
+	Color              string     `json:"color"`
+	Status             string     `json:"status"`
+	Position           int        `json:"position"` //1 is next in line, 0 once the entry has an offer
+	Offered_Printer_Id int        `json:"offered_printer_id"`
+	Offer_Expires_At   *time.Time `json:"offer_expires_at"`
+	Created_At         time.Time  `json:"created_at"`
+}
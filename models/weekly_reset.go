@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+//one run of the weekly minutes reset
+type WeeklyResetRun struct {
+	Id                   int       `json:"id"`
+	Period_Start         time.Time `json:"period_start"` //the scheduled reset time the run was for, earlier than Ran_At for catch-up runs
+	Ran_At               time.Time `json:"ran_at"`
+	Triggered_By         string    `json:"triggered_by"` //scheduled or catch_up
+	Weekly_Minutes       int       `json:"weekly_minutes"`
+	Rollover_Cap_Minutes int       `json:"rollover_cap_minutes"`
+	Users_Reset          int       `json:"users_reset"`
+	Minutes_Rolled_Over  int       `json:"minutes_rolled_over"` //total across every user
+}
+
+//the weekly reset settings along with when the reset last ran and will run next
+type WeeklyResetStatus struct {
+	Settings   ResetSettings   `json:"settings"`
+	Last_Run   *WeeklyResetRun `json:"last_run"` //nil if the API hasn't run a reset yet
+	Next_Reset time.Time       `json:"next_reset"`
+}
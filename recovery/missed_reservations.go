@@ -3,58 +3,94 @@ package recovery
 import (
 	"fmt"
 	"gin-api/database"
+	"gin-api/models"
 	"gin-api/services"
-	"gin-api/util"
 	"time"
 )
 
+//summary of what startup recovery did with each reservation it found, logged by main.go
+type RecoveryReport struct {
+	Completed []int    `json:"completed"` //active reservations that ended while the API was offline
+	Restored  []int    `json:"restored"`  //active reservations still running, printer back on and completion re-scheduled
+	Upcoming  []int    `json:"upcoming"`  //future reservations whose start jobs were checked
+	Started   []int    `json:"started"`   //future reservations whose window began while the API was offline
+	Failed    []string `json:"failed"`    //reservations that could not be recovered, with the reason
+}
+
+func (r RecoveryReport) String() string {
+	return fmt.Sprintf("completed %d, restored %d, re-scheduled %d upcoming, started %d upcoming, failed %d",
+		len(r.Completed), len(r.Restored), len(r.Upcoming), len(r.Started), len(r.Failed))
+}
+
 //runs on startup (in main.go). Finds reservations that ended while the API was not running,
 //and completes those reservations formally. Finds reservations that haven't ended while the
-//API was not running, and re-enables their printer's respective GPIO pin.
-func CompleteMissedReservations() (bool, error) {
+//API was not running, re-enables their printer's respective GPIO pin, and adds them back to the
+//reservation manager so they still complete at their time_complete. Upcoming reservations
+//get their start jobs checked, or are started right away if their window already began.
+func CompleteMissedReservations() (*RecoveryReport, error) {
+	report := &RecoveryReport{}
 
-	//pull id, printer_id, time_complete of all active reservations
-	querySQL := `SELECT id, printerid, time_complete FROM reservations WHERE is_active = ?`
-	rows, err := database.DB.Query(querySQL, true)
+	active, err := queryReservations(`SELECT id, printerid, userId, time_reserved, time_complete FROM reservations WHERE is_active = ?`)
 	if err != nil {
-		return false, fmt.Errorf("failed to query failsafe reservations: %v", err)
+		return report, fmt.Errorf("failed to query failsafe reservations: %v", err)
 	}
 
-	var reservations []struct {
-		id           int
-		printerId    int
-		timeComplete time.Time
+	for _, r := range active {
+		if r.Time_Complete.Before(time.Now()) { //if reservation still is_active but its end time has passed, it was missed in downtime. Complete it.
+			services.CompleteReservation(r.PrinterId, r.Id)
+			report.Completed = append(report.Completed, r.Id)
+		} else if err := services.RestoreActiveReservation(r); err != nil { //if end time has not passed yet, turn the printer back on and re-arm it
+			report.Failed = append(report.Failed, fmt.Sprintf("reservation %d: %v", r.Id, err))
+		} else {
+			report.Restored = append(report.Restored, r.Id)
+		}
 	}
 
-	var errRows error
+	upcoming, err := queryReservations(`SELECT id, printerid, userId, time_reserved, time_complete FROM reservations WHERE is_scheduled = ?`)
+	if err != nil {
+		return report, fmt.Errorf("failed to query upcoming reservations: %v", err)
+	}
 
-	//iterate through all rows pulled out from query
-	for rows.Next() {
-		var r struct {
-			id           int
-			printerId    int
-			timeComplete time.Time
-		}
-		errRows = rows.Scan(&r.id, &r.printerId, &r.timeComplete)
-		if errRows != nil {
-			return false, fmt.Errorf("error scanning row %d: %v", r.id, err)
+	for _, r := range upcoming {
+		if r.Time_Reserved.After(time.Now()) { //still in the future, wait for it
+			if err := services.RestoreUpcomingReservation(r); err != nil {
+				report.Failed = append(report.Failed, fmt.Sprintf("upcoming reservation %d: %v", r.Id, err))
+			} else {
+				report.Upcoming = append(report.Upcoming, r.Id)
+			}
+		} else if err := services.StartScheduledReservation(r.Id); err != nil { //window started (or already ended) while offline
+			report.Failed = append(report.Failed, fmt.Sprintf("upcoming reservation %d: %v", r.Id, err))
+		} else {
+			report.Started = append(report.Started, r.Id)
 		}
-		reservations = append(reservations, r)
 	}
 
-	//if there was a rows error during the loop, return it
-	if errRows = rows.Err(); errRows != nil {
-		return false, fmt.Errorf("error occurred during rows iteration: %v", err)
+	return report, nil
+}
+
+//given a query selecting id, printerid, userId, time_reserved, time_complete filtered on one boolean,
+//return the matching reservations
+func queryReservations(querySQL string) ([]models.Reservation, error) {
+	rows, err := database.DB.Query(querySQL, true)
+	if err != nil {
+		return nil, err
 	}
-	rows.Close()
+	defer rows.Close()
+
+	var reservations []models.Reservation
 
-	for _, r := range reservations {
-		if r.timeComplete.Before(time.Now()) { //if reservation still is_active but its end time has passed, it was missed in downtime. Complete it.
-			services.CompleteReservation(r.printerId, r.id)
-		} else { //if end time has not passed yet, turn the printer back on.
-			util.TurnOnPrinter(r.printerId)
+	//iterate through all rows pulled out from query
+	for rows.Next() {
+		var r models.Reservation
+		if err := rows.Scan(&r.Id, &r.PrinterId, &r.UserId, &r.Time_Reserved, &r.Time_Complete); err != nil {
+			return nil, fmt.Errorf("error scanning row %d: %v", r.Id, err)
 		}
+		reservations = append(reservations, r)
 	}
 
-	return true, nil
+	//if there was a rows error during the loop, return it
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred during rows iteration: %v", err)
+	}
+	return reservations, nil
 }
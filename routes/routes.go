@@ -3,6 +3,7 @@ package routes
 import (
 	"gin-api/controllers"
 	"gin-api/middleware"
+	"gin-api/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,6 +19,7 @@ func SetupRouter(r *gin.Engine) {
 		{
 			auth.POST("/login", controllers.Login)
 			auth.POST("/refreshToken", controllers.RefreshToken)
+			auth.POST("/logout", controllers.Logout)
 		}
 		protected := api.Group("") //protected: only available to users with a valid token
 		protected.Use(middleware.AuthMiddleware())
@@ -31,16 +33,20 @@ func SetupRouter(r *gin.Engine) {
 			users := protected.Group("/users") //user-level user routes
 			{
 				users.GET("/reservations/:userID",
+					middleware.UserOwnershipPermission(),
 					controllers.GetUserReservations,
 				)
 				users.GET("/activeReservations/:userID",
+					middleware.UserOwnershipPermission(),
 					controllers.GetActiveUserReservations,
 				)
 				users.PUT("/cancelActiveReservation",
 					controllers.CancelActiveReservation,
 				)
 				users.GET("/weeklyMinutes/:userID",
+					middleware.UserOwnershipPermission(),
 					controllers.GetUserWeeklyMinutes,)
+				users.GET("/minuteStatement", controllers.GetMyMinuteStatement)
 			}
 			settings := protected.Group("/settings") //user-level settings routes
 			{
@@ -50,40 +56,129 @@ func SetupRouter(r *gin.Engine) {
 			{
 				reservations.GET("/getActiveReservations", controllers.GetActiveReservations)
 				reservations.PUT("/cancel", controllers.CancelActiveReservation)
+				reservations.PUT("/extend", controllers.ExtendReservation)
+				reservations.PUT("/reportFailure", controllers.ReportPrintFailure)
+				reservations.PUT("/checkIn", controllers.CheckIn)
+			}
+			closures := protected.Group("/closures") //user-level closure routes
+			{
+				closures.GET("/getUpcomingClosures", controllers.GetUpcomingClosures)
+				closures.GET("/getOperatingHours", controllers.GetOperatingHours)
+			}
+			waitlist := protected.Group("/waitlist") //user-level waitlist routes
+			{
+				waitlist.POST("/join", controllers.JoinWaitlist)
+				waitlist.PUT("/leave/:entryID", controllers.LeaveWaitlist)
+				waitlist.GET("/getWaitlist", controllers.GetWaitlist)
+				waitlist.GET("/getWaitlist/printer/:printerID", controllers.GetPrinterWaitlist)
+				waitlist.GET("/myEntries", controllers.GetMyWaitlist)
 			}
 
-			//Admin routes
-			admin := protected.Group("/admin") //admin: only available to admin users
-			admin.Use(middleware.AdminPermission())
+			//Admin routes: each group is only available to users whose role grants the group's permission
+			admin := protected.Group("/admin")
 			{
-				users := admin.Group("/users") //admin-level user routes
+				users := admin.Group("/users", middleware.RequirePermission(models.PermManageUsers)) //admin-level user routes
 				{
 					users.POST("/create", controllers.CreateUser)
 					users.POST("/getUser", controllers.GetUserById)
-					users.PUT("/setTrained/:userID", controllers.SetUserTrained)
 					users.PUT("/setExecutiveAccess/:userID", controllers.SetUserExecutiveAccess)
+					users.PUT("/setEgnLab/:userID", controllers.SetUserEgnLab)
 					users.PUT("/addWeeklyMinutes/:userID", controllers.AddUserWeeklyMinutes)
 					users.PUT("/setBanTime/:userID", controllers.SetUserBanTime)
+					users.GET("/minuteStatement/:userID", controllers.GetUserMinuteStatement)
+					users.GET("/minuteDiscrepancies", controllers.GetMinuteDiscrepancies)
+					users.PUT("/reconcileMinutes", controllers.ReconcileMinutes)
+					users.PUT("/setQuotaTier/:userID", controllers.SetUserQuotaTier)
+					users.PUT("/revokeSessions/:userID", controllers.RevokeUserSessions)
+					users.GET("/credentials/:userID", controllers.GetUserCredentials)
+					users.POST("/addCredential/:userID", controllers.AddCredential)
+					users.PUT("/revokeCredential/:credentialID", controllers.RevokeCredential)
+					users.PUT("/replaceCredential/:credentialID", controllers.ReplaceCredential)
+				}
+				training := admin.Group("/users", middleware.RequirePermission(models.PermTrainUsers)) //user training routes
+				{
+					training.PUT("/setTrained/:userID", controllers.SetUserTrained)
 				}
-				printers := admin.Group("/printers") //admin-level printers routes
+				printers := admin.Group("/printers", middleware.RequirePermission(models.PermManagePrinters)) //admin-level printers routes
 				{
 					printers.POST("/create", controllers.AddPrinter)
 					printers.PUT("/setExecutive/:printerID", controllers.SetPrinterExecutive)
+					printers.PUT("/setEgn/:printerID", controllers.SetPrinterEgn)
 					printers.PUT("/update/:printerID", controllers.UpdatePrinter)
 					printers.DELETE("/delete/:printerID", controllers.DeletePrinter)
 				}
-				settings := admin.Group("/settings") //admin-level settings routes
+				maintenance := admin.Group("/printers", middleware.RequirePermission(models.PermPrinterMaintenance)) //printer status and maintenance routes
+				{
+					maintenance.PUT("/setStatus/:printerID", controllers.SetPrinterStatus)
+					maintenance.PUT("/setMaintenance/:printerID", controllers.SetPrinterMaintenance)
+					maintenance.PUT("/clearMaintenance/:printerID", controllers.ClearPrinterMaintenance)
+					maintenance.GET("/statusHistory/:printerID", controllers.GetPrinterStatusHistory)
+				}
+				settings := admin.Group("/settings", middleware.RequirePermission(models.PermManageSettings)) //admin-level settings routes
 				{
 					settings.PUT("/setTimeSettings", controllers.SetTimeSettings)
 					settings.GET("/getPrinterSettings", controllers.GetPrinterSettings)
 					settings.PUT("/setPrinterSettings", controllers.SetPrinterSettings)
+					settings.GET("/getRefundPolicies", controllers.GetRefundPolicies)
+					settings.PUT("/setRefundPolicy", controllers.SetRefundPolicy)
+					settings.GET("/getWeeklyReset", controllers.GetWeeklyResetStatus)
+					settings.GET("/getWeeklyResetRuns", controllers.GetWeeklyResetRuns)
+					settings.PUT("/setResetSettings", controllers.SetResetSettings)
+					settings.GET("/getCardSettings", controllers.GetCardSettings)
+					settings.PUT("/setCardSettings", controllers.SetCardSettings)
+					settings.GET("/getSigningKeys", controllers.GetSigningKeys)
+					settings.PUT("/rotateSigningKey", controllers.RotateSigningKey)
 				}
-				data := admin.Group("/data") //admin-level data management routes
+				data := admin.Group("/data", middleware.RequirePermission(models.PermManageData)) //admin-level data management routes
 				{
 					data.POST("/exportDB", controllers.ExportDbToUsb)
 					data.POST("/importDB", controllers.ImportDbFromUsb)
 					data.PUT("/ejectUSB", controllers.EjectUSB)
 				}
+				reservations := admin.Group("/reservations", middleware.RequirePermission(models.PermManageReservations)) //admin-level reservations routes
+				{
+					reservations.PUT("/forceEnd", controllers.ForceEndReservation)
+				}
+				blocks := admin.Group("/reservations", middleware.RequirePermission(models.PermBookBlocks)) //EGN block routes
+				{
+					blocks.POST("/egnBlock", controllers.BookEgnBlock)
+					blocks.GET("/getEgnReservations", controllers.GetEgnReservations)
+				}
+				series := admin.Group("/series", middleware.RequirePermission(models.PermBookBlocks)) //admin-level recurring reservation routes
+				{
+					series.POST("/create", controllers.CreateReservationSeries)
+					series.GET("/getSeries", controllers.GetReservationSeries)
+					series.GET("/occurrences/:seriesID", controllers.GetSeriesOccurrences)
+					series.PUT("/update/:seriesID", controllers.UpdateReservationSeries)
+					series.PUT("/cancel/:seriesID", controllers.CancelReservationSeries)
+					series.PUT("/occurrence/update/:reservationID", controllers.UpdateSeriesOccurrence)
+					series.PUT("/occurrence/cancel/:reservationID", controllers.CancelSeriesOccurrence)
+				}
+				closures := admin.Group("/closures", middleware.RequirePermission(models.PermManageClosures)) //admin-level closure routes
+				{
+					closures.POST("/create", controllers.CreateClosure)
+					closures.DELETE("/delete/:closureID", controllers.DeleteClosure)
+					closures.PUT("/setOperatingHours", controllers.SetOperatingHours)
+				}
+				tiers := admin.Group("/tiers", middleware.RequirePermission(models.PermManageSettings)) //admin-level quota tier routes
+				{
+					tiers.GET("/getTiers", controllers.GetQuotaTiers)
+					tiers.POST("/create", controllers.CreateQuotaTier)
+					tiers.PUT("/update/:tierID", controllers.UpdateQuotaTier)
+					tiers.DELETE("/delete/:tierID", controllers.DeleteQuotaTier)
+				}
+				jobs := admin.Group("/jobs", middleware.RequirePermission(models.PermViewJobs)) //admin-level scheduled job routes
+				{
+					jobs.GET("/getPendingJobs", controllers.GetPendingJobs)
+				}
+				roles := admin.Group("/roles", middleware.RequirePermission(models.PermManageRoles)) //admin-level role routes
+				{
+					roles.GET("/getRoles", controllers.GetRoles)
+					roles.POST("/create", controllers.CreateRole)
+					roles.PUT("/setPermissions/:roleID", controllers.SetRolePermissions)
+					roles.DELETE("/delete/:roleID", controllers.DeleteRole)
+					roles.PUT("/setUserRole/:userID", controllers.SetUserRole)
+				}
 			}
 
 		}
@@ -0,0 +1,306 @@
+package scheduler
+
+import (
+	"database/sql"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+//job types run by the scheduler. Handlers for them are registered by the services package on startup.
+const (
+	JobStartReservation    = "start_reservation"     //start an upcoming reservation when its window begins
+	JobCompleteReservation = "complete_reservation"  //end a reservation at its time_complete
+	JobWarnUser            = "warn_user"             //warn the user their reservation is about to end
+	JobWeeklyReset         = "weekly_reset"          //reset every user's weekly minutes
+	JobExpireWaitlistOffer = "expire_waitlist_offer" //pass a printer on if its waitlist offer wasn't claimed
+	JobClearBan            = "clear_ban"             //set a user's ban_time_end back to NULL once the ban is over
+	JobCheckInDeadline     = "check_in_deadline"     //release a reservation whose user never checked in
+)
+
+//job statuses stored in scheduled_jobs.status
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+const (
+	maxAttempts   = 5                //a job is marked failed after this many unsuccessful runs
+	retryDelay    = 30 * time.Second //delay before the first retry, doubled for every attempt after that
+	sweepInterval = 30 * time.Second //how often the database is checked for due jobs that no timer picked up
+)
+
+//runs a job. Jobs are delivered at least once, so a handler must be safe to run again for the same job.
+type Handler func(job models.Job) error
+
+var (
+	handlers = make(map[string]Handler)
+	timers   = make(map[int]*time.Timer) //in-memory timers for pending jobs, keyed by job id
+	mutex    sync.Mutex
+	started  bool
+)
+
+//register the function that runs jobs of the given type. Must be called before Start.
+func RegisterHandler(jobType string, handler Handler) {
+	mutex.Lock()
+	handlers[jobType] = handler
+	mutex.Unlock()
+}
+
+//runs on startup (in main.go). Jobs that were running when the API stopped are put back to pending,
+//every pending job gets a timer (overdue jobs run right away), and a periodic sweep is started as a
+//backstop for timers that drift or are missed.
+func Start() error {
+	//an interrupted job that was already scheduled again (pending, or running as a newer job) is cancelled,
+	//since there can only be one pending job per type and reference
+	_, err := database.DB.Exec(`UPDATE scheduled_jobs SET status = ? WHERE status = ? AND EXISTS (
+									SELECT 1 FROM scheduled_jobs other WHERE other.job_type = scheduled_jobs.job_type
+									AND other.reference_id = scheduled_jobs.reference_id AND other.id != scheduled_jobs.id
+									AND (other.status = ? OR (other.status = ? AND other.id > scheduled_jobs.id)))`,
+		StatusCancelled, StatusRunning, StatusPending, StatusRunning)
+	if err != nil {
+		return fmt.Errorf("error cancelling superseded interrupted jobs: %v", err)
+	}
+	_, err = database.DB.Exec("UPDATE scheduled_jobs SET status = ? WHERE status = ?", StatusPending, StatusRunning)
+	if err != nil {
+		return fmt.Errorf("error resetting interrupted jobs: %v", err)
+	}
+
+	jobs, err := GetPendingJobs()
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	started = true
+	mutex.Unlock()
+
+	for _, job := range jobs {
+		arm(job.Id, job.RunAt)
+	}
+	log.Printf("Scheduler started with %d pending job(s)", len(jobs))
+
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sweep()
+		}
+	}()
+	return nil
+}
+
+//given a job type, reference id, and time, make sure exactly one pending job of that type exists for the
+//reference and that it runs at runAt. An existing pending job is moved rather than duplicated. This is one
+//upsert against idx_scheduled_jobs_pending, so concurrent callers can't both add a job. Returns the job id.
+func Schedule(jobType string, referenceId int, runAt time.Time) (int, error) {
+	var jobId int
+	upsertSQL := `INSERT INTO scheduled_jobs (job_type, reference_id, run_at, status) VALUES (?, ?, ?, ?)
+				ON CONFLICT (job_type, reference_id) WHERE status = 'pending' DO UPDATE SET run_at = excluded.run_at
+				RETURNING id`
+	err := database.DB.QueryRow(upsertSQL, jobType, referenceId, runAt.UTC(), StatusPending).Scan(&jobId)
+	if err != nil {
+		return 0, fmt.Errorf("error scheduling %s job for %d: %v", jobType, referenceId, err)
+	}
+
+	arm(jobId, runAt)
+	return jobId, nil
+}
+
+//given a job type and reference id, cancel any pending job of that type for the reference
+func Cancel(jobType string, referenceId int) error {
+	rows, err := database.DB.Query(`SELECT id FROM scheduled_jobs WHERE job_type = ? AND reference_id = ? AND status = ?`,
+		jobType, referenceId, StatusPending)
+	if err != nil {
+		return fmt.Errorf("error finding %s jobs to cancel: %v", jobType, err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning job id: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		disarm(id)
+		_, err := database.DB.Exec("UPDATE scheduled_jobs SET status = ? WHERE id = ? AND status = ?", StatusCancelled, id, StatusPending)
+		if err != nil {
+			return fmt.Errorf("error cancelling job %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+//given a job type and reference id, return whether a pending job of that type exists for the reference
+func HasPending(jobType string, referenceId int) (bool, error) {
+	var count int
+	err := database.DB.QueryRow(`SELECT COUNT(*) FROM scheduled_jobs WHERE job_type = ? AND reference_id = ? AND status = ?`,
+		jobType, referenceId, StatusPending).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for pending %s job: %v", jobType, err)
+	}
+	return count > 0, nil
+}
+
+//return all pending jobs, soonest first
+func GetPendingJobs() ([]models.Job, error) {
+	querySQL := `SELECT id, job_type, reference_id, run_at, status, attempts, last_error, created_at
+				FROM scheduled_jobs WHERE status = ? ORDER BY run_at ASC`
+	rows, err := database.DB.Query(querySQL, StatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("error getting pending jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		var job models.Job
+		var lastError sql.NullString
+		if err := rows.Scan(&job.Id, &job.Type, &job.ReferenceId, &job.RunAt, &job.Status, &job.Attempts, &lastError, &job.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning job: %v", err)
+		}
+		job.LastError = lastError.String
+		jobs = append(jobs, job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return jobs, nil
+}
+
+//set (or replace) the in-memory timer that runs a job at runAt. Before Start is called jobs are only stored,
+//Start arms everything that is pending.
+func arm(jobId int, runAt time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !started {
+		return
+	}
+	if timer, ok := timers[jobId]; ok {
+		timer.Stop()
+	}
+	timers[jobId] = time.AfterFunc(time.Until(runAt), func() {
+		run(jobId)
+	})
+}
+
+//stop and forget the in-memory timer of a job
+func disarm(jobId int) {
+	mutex.Lock()
+	if timer, ok := timers[jobId]; ok {
+		timer.Stop()
+		delete(timers, jobId)
+	}
+	mutex.Unlock()
+}
+
+//run every pending job whose run_at has passed. Compared in Go since run_at is stored as text.
+func sweep() {
+	jobs, err := GetPendingJobs()
+	if err != nil {
+		log.Printf("scheduler sweep failed: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, job := range jobs {
+		if !job.RunAt.After(now) {
+			run(job.Id)
+		}
+	}
+}
+
+//run a job's handler. A panic is returned as an error, so the job is retried or marked failed like any other
+//failure instead of taking the API down and leaving the job running.
+func runHandler(handler Handler, job models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s job %d for %d panicked: %v\n%s", job.Type, job.Id, job.ReferenceId, r, debug.Stack())
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return handler(job)
+}
+
+//claim a pending job and run its handler. The claim is a conditional update, so a job fired by both its timer
+//and the sweep only runs once. Failed jobs are retried with a growing delay until maxAttempts is reached.
+func run(jobId int) {
+	disarm(jobId)
+
+	result, err := database.DB.Exec(`UPDATE scheduled_jobs SET status = ?, attempts = attempts + 1 WHERE id = ? AND status = ?`,
+		StatusRunning, jobId, StatusPending)
+	if err != nil {
+		log.Printf("failed to claim job %d: %v", jobId, err)
+		arm(jobId, time.Now().Add(retryDelay))
+		return
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		return //already claimed, cancelled or finished
+	}
+
+	var job models.Job
+	querySQL := `SELECT id, job_type, reference_id, run_at, status, attempts, created_at FROM scheduled_jobs WHERE id = ?`
+	err = database.DB.QueryRow(querySQL, jobId).Scan(&job.Id, &job.Type, &job.ReferenceId, &job.RunAt, &job.Status, &job.Attempts, &job.CreatedAt)
+	if err != nil {
+		log.Printf("failed to load claimed job %d: %v", jobId, err)
+		return //left as running, Start puts it back to pending on the next boot
+	}
+
+	mutex.Lock()
+	handler, ok := handlers[job.Type]
+	mutex.Unlock()
+
+	if !ok {
+		err = fmt.Errorf("no handler registered for job type %s", job.Type)
+	} else {
+		err = runHandler(handler, job)
+	}
+
+	if err == nil {
+		_, err = database.DB.Exec(`UPDATE scheduled_jobs SET status = ?, completed_at = ?, last_error = NULL WHERE id = ?`,
+			StatusDone, time.Now().UTC(), job.Id)
+		if err != nil {
+			log.Printf("job %d ran but could not be marked done: %v", job.Id, err)
+		}
+		return
+	}
+
+	if job.Attempts >= maxAttempts {
+		log.Printf("CRITICAL: %s job %d for %d failed after %d attempts: %v", job.Type, job.Id, job.ReferenceId, job.Attempts, err)
+		_, dbErr := database.DB.Exec(`UPDATE scheduled_jobs SET status = ?, last_error = ? WHERE id = ?`, StatusFailed, err.Error(), job.Id)
+		if dbErr != nil {
+			log.Printf("failed to mark job %d failed: %v", job.Id, dbErr)
+		}
+		return
+	}
+
+	//the handler may have scheduled the same job again, that job takes the place of the retry
+	nextRun := time.Now().Add(retryDelay * time.Duration(1<<(job.Attempts-1)))
+	result, dbErr := database.DB.Exec(`UPDATE scheduled_jobs SET status = ?, run_at = ?, last_error = ? WHERE id = ? AND NOT EXISTS (
+										SELECT 1 FROM scheduled_jobs WHERE job_type = ? AND reference_id = ? AND status = ?)`,
+		StatusPending, nextRun.UTC(), err.Error(), job.Id, job.Type, job.ReferenceId, StatusPending)
+	if dbErr != nil {
+		log.Printf("failed to reschedule job %d: %v", job.Id, dbErr)
+		return
+	}
+	if rowsAffected, rowsErr := result.RowsAffected(); rowsErr == nil && rowsAffected == 0 {
+		log.Printf("%s job %d for %d failed (attempt %d), a pending job replaces its retry: %v", job.Type, job.Id, job.ReferenceId, job.Attempts, err)
+		_, dbErr = database.DB.Exec(`UPDATE scheduled_jobs SET status = ?, last_error = ? WHERE id = ?`, StatusCancelled, err.Error(), job.Id)
+		if dbErr != nil {
+			log.Printf("failed to cancel replaced job %d: %v", job.Id, dbErr)
+		}
+		return
+	}
+	log.Printf("%s job %d for %d failed (attempt %d), retrying at %s: %v", job.Type, job.Id, job.ReferenceId, job.Attempts, nextRun.Format(time.RFC3339), err)
+	arm(job.Id, nextRun)
+}
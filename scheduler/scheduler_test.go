@@ -0,0 +1,288 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"gin-api/database"
+	"gin-api/models"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testJob = "test_job"
+
+// open a new database set up by EnsureSchema. Start isn't called, so jobs only run when a test runs them.
+func setupSchedulerTest(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	previousDB := database.DB
+	database.SetDB(db)
+	t.Cleanup(func() {
+		database.SetDB(previousDB)
+		db.Close()
+		mutex.Lock()
+		delete(handlers, testJob)
+		mutex.Unlock()
+	})
+
+	if err := database.EnsureSchema(); err != nil {
+		t.Fatalf("error setting up database: %v", err)
+	}
+}
+
+type jobRow struct {
+	status    string
+	attempts  int
+	runAt     time.Time
+	lastError sql.NullString
+}
+
+func getJobRow(t *testing.T, jobId int) jobRow {
+	t.Helper()
+	var row jobRow
+	err := database.DB.QueryRow("SELECT status, attempts, run_at, last_error FROM scheduled_jobs WHERE id = ?", jobId).Scan(
+		&row.status, &row.attempts, &row.runAt, &row.lastError)
+	if err != nil {
+		t.Fatalf("error getting job %d: %v", jobId, err)
+	}
+	return row
+}
+
+func TestRunRetriesWithBackoff(t *testing.T) {
+	setupSchedulerTest(t)
+	RegisterHandler(testJob, func(job models.Job) error { return errors.New("printer offline") })
+
+	jobId, err := Schedule(testJob, 1, time.Now())
+	if err != nil {
+		t.Fatalf("error scheduling job: %v", err)
+	}
+
+	tests := []struct {
+		attempt    int
+		wantStatus string
+		wantDelay  time.Duration // from when the attempt ran to the next run_at, for retried jobs
+	}{
+		{attempt: 1, wantStatus: StatusPending, wantDelay: 30 * time.Second},
+		{attempt: 2, wantStatus: StatusPending, wantDelay: time.Minute},
+		{attempt: 3, wantStatus: StatusPending, wantDelay: 2 * time.Minute},
+		{attempt: 4, wantStatus: StatusPending, wantDelay: 4 * time.Minute},
+		{attempt: maxAttempts, wantStatus: StatusFailed},
+	}
+	for _, tt := range tests {
+		ranAt := time.Now()
+		run(jobId)
+		row := getJobRow(t, jobId)
+		if row.status != tt.wantStatus || row.attempts != tt.attempt {
+			t.Fatalf("attempt %d: got status %s after %d attempts, want %s", tt.attempt, row.status, row.attempts, tt.wantStatus)
+		}
+		if row.lastError.String != "printer offline" {
+			t.Errorf("attempt %d: got last_error %q", tt.attempt, row.lastError.String)
+		}
+		if tt.wantStatus == StatusPending {
+			if delay := row.runAt.Sub(ranAt); delay < tt.wantDelay || delay > tt.wantDelay+5*time.Second {
+				t.Errorf("attempt %d: retried after %s, want %s", tt.attempt, delay, tt.wantDelay)
+			}
+		}
+	}
+
+	// a failed job is never claimed again
+	run(jobId)
+	if row := getJobRow(t, jobId); row.status != StatusFailed || row.attempts != maxAttempts {
+		t.Errorf("failed job ran again: status %s after %d attempts", row.status, row.attempts)
+	}
+}
+
+func TestRunSucceedsAfterRetry(t *testing.T) {
+	setupSchedulerTest(t)
+	calls := 0
+	RegisterHandler(testJob, func(job models.Job) error {
+		calls++
+		if calls == 1 {
+			return errors.New("printer offline")
+		}
+		return nil
+	})
+
+	jobId, err := Schedule(testJob, 1, time.Now())
+	if err != nil {
+		t.Fatalf("error scheduling job: %v", err)
+	}
+	run(jobId)
+	run(jobId)
+
+	row := getJobRow(t, jobId)
+	if row.status != StatusDone || row.attempts != 2 || row.lastError.Valid {
+		t.Errorf("got status %s after %d attempts (last_error %q), want done after 2 with no error", row.status, row.attempts, row.lastError.String)
+	}
+	run(jobId)
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestRunRecoversFromPanic(t *testing.T) {
+	setupSchedulerTest(t)
+	RegisterHandler(testJob, func(job models.Job) error {
+		var printers map[int]string
+		printers[job.ReferenceId] = "on" //assignment to a nil map
+		return nil
+	})
+
+	jobId, err := Schedule(testJob, 1, time.Now())
+	if err != nil {
+		t.Fatalf("error scheduling job: %v", err)
+	}
+	run(jobId)
+
+	row := getJobRow(t, jobId)
+	if row.status != StatusPending || row.attempts != 1 {
+		t.Fatalf("got status %s after %d attempts, want pending after 1", row.status, row.attempts)
+	}
+	if !strings.Contains(row.lastError.String, "panicked") {
+		t.Errorf("got last_error %q, want the panic", row.lastError.String)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	setupSchedulerTest(t)
+	calls := 0
+	RegisterHandler(testJob, func(job models.Job) error {
+		calls++
+		return nil
+	})
+
+	jobId, err := Schedule(testJob, 1, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error scheduling job: %v", err)
+	}
+	otherId, err := Schedule(testJob, 2, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error scheduling job: %v", err)
+	}
+
+	if err := Cancel(testJob, 1); err != nil {
+		t.Fatalf("error cancelling job: %v", err)
+	}
+	if row := getJobRow(t, jobId); row.status != StatusCancelled {
+		t.Errorf("got status %s, want cancelled", row.status)
+	}
+	if pending, err := HasPending(testJob, 1); err != nil || pending {
+		t.Errorf("got pending %v (%v) after cancelling", pending, err)
+	}
+	if row := getJobRow(t, otherId); row.status != StatusPending {
+		t.Errorf("job for another reference got status %s, want pending", row.status)
+	}
+
+	// a cancelled job is never run, even if its timer fires
+	run(jobId)
+	if calls != 0 {
+		t.Errorf("cancelled job ran %d times", calls)
+	}
+
+	// cancelling again is a no-op, scheduling again adds a new job
+	if err := Cancel(testJob, 1); err != nil {
+		t.Fatalf("error cancelling again: %v", err)
+	}
+	newId, err := Schedule(testJob, 1, time.Now())
+	if err != nil {
+		t.Fatalf("error scheduling job: %v", err)
+	}
+	if newId == jobId {
+		t.Errorf("scheduling after a cancel reused cancelled job %d", jobId)
+	}
+}
+
+func TestScheduleMovesPendingJob(t *testing.T) {
+	setupSchedulerTest(t)
+
+	firstRun := time.Now().Add(time.Hour).Truncate(time.Second)
+	jobId, err := Schedule(testJob, 1, firstRun)
+	if err != nil {
+		t.Fatalf("error scheduling job: %v", err)
+	}
+	secondRun := firstRun.Add(time.Hour)
+	movedId, err := Schedule(testJob, 1, secondRun)
+	if err != nil {
+		t.Fatalf("error rescheduling job: %v", err)
+	}
+	if movedId != jobId {
+		t.Fatalf("rescheduling added job %d instead of moving job %d", movedId, jobId)
+	}
+
+	jobs, err := GetPendingJobs()
+	if err != nil {
+		t.Fatalf("error getting pending jobs: %v", err)
+	}
+	if len(jobs) != 1 || !jobs[0].RunAt.Equal(secondRun) {
+		t.Errorf("got pending jobs %+v, want one at %s", jobs, secondRun)
+	}
+}
+
+func TestScheduleConcurrentCallers(t *testing.T) {
+	setupSchedulerTest(t)
+
+	const callers = 10
+	ids := make([]int, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = Schedule(testJob, 1, time.Now().Add(time.Duration(i)*time.Minute))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		if ids[i] != ids[0] {
+			t.Errorf("caller %d got job %d, caller 0 got job %d", i, ids[i], ids[0])
+		}
+	}
+	jobs, err := GetPendingJobs()
+	if err != nil {
+		t.Fatalf("error getting pending jobs: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Errorf("got %d pending jobs, want 1", len(jobs))
+	}
+}
+
+func TestRunRetryReplacedByPendingJob(t *testing.T) {
+	setupSchedulerTest(t)
+	var nextId int
+	RegisterHandler(testJob, func(job models.Job) error {
+		var err error
+		if nextId, err = Schedule(testJob, job.ReferenceId, time.Now().Add(time.Minute)); err != nil {
+			return err
+		}
+		return errors.New("printer still in use")
+	})
+
+	jobId, err := Schedule(testJob, 1, time.Now())
+	if err != nil {
+		t.Fatalf("error scheduling job: %v", err)
+	}
+	run(jobId)
+
+	if nextId == jobId {
+		t.Fatalf("the handler's job reused running job %d", jobId)
+	}
+	if row := getJobRow(t, jobId); row.status != StatusCancelled {
+		t.Errorf("failed job got status %s, want cancelled", row.status)
+	}
+	if row := getJobRow(t, nextId); row.status != StatusPending {
+		t.Errorf("the handler's job got status %s, want pending", row.status)
+	}
+}
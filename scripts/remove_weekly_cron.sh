@@ -0,0 +1,16 @@
+#!/bin/bash
+
+# The weekly minutes reset now runs inside the API (see services/weekly_reset_service.go).
+# Run this once on machines that were set up with the old cron_setup.sh to remove the
+# weekly.sh cron jobs, which would otherwise reset everyone's minutes a second time.
+
+# Backup the current system-wide crontab
+sudo crontab -l > /tmp/current_crontab 2>/dev/null
+
+# Keep every job except the weekly.sh ones and their comments
+grep -v -e "weekly.sh" -e "# Run every monday morning at 8:00 AM" -e "# Run on reboot" /tmp/current_crontab | sudo crontab -
+
+# Cleanup the temporary file
+rm -f /tmp/current_crontab
+
+echo "Weekly reset cron jobs removed!"
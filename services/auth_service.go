@@ -7,6 +7,7 @@ import (
 	"gin-api/database"
 	"gin-api/models"
 	"gin-api/util"
+	"log"
 )
 
 type LoginRequest struct {
@@ -26,31 +27,69 @@ func Login(loginRequest LoginRequest) (*models.UserData, *util.TokenPair, error)
         return nil, tokenPair, fmt.Errorf("error parsing card data: %v", err)
     }
 
+	userId, err := resolveCredential(database.DB, cardData)
+	if err != nil {
+		return nil, tokenPair, err
+	}
+
 	var userData models.UserData
-	err = database.DB.QueryRow("SELECT id, username, has_training, admin, has_executive_access, ban_time_end, weekly_minutes FROM users WHERE id = ?", cardData.Id).Scan(
+	err = database.DB.QueryRow("SELECT id, username, has_training, admin, has_executive_access, is_egn_lab, ban_time_end, weekly_minutes, no_show_count, COALESCE(quota_tier_id, 0), COALESCE((SELECT name FROM roles WHERE id = users.role_id), 'user') FROM users WHERE id = ?", userId).Scan(
 		&userData.Id,
 		&userData.Username,
 		&userData.Trained,
 		&userData.Admin,
 		&userData.Has_Executive_Access,
+		&userData.Is_Egn_Lab,
 		&userData.Ban_Time_End,
 		&userData.Weekly_Minutes,
+		&userData.No_Show_Count,
+		&userData.Quota_Tier_Id,
+		&userData.Role,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			log.Printf("Rejected %s card scan: the card's user %d no longer exists", cardData.Format, userId)
             return nil, tokenPair, ErrorUserNotFound
 		}
 		return nil, tokenPair, fmt.Errorf("database error: %v", err)
 	}
 
 	if !userData.Trained {
+		log.Printf("Rejected %s card scan: user %d is not trained", cardData.Format, userData.Id)
         return nil, tokenPair, ErrorNotTrained
     }
 
-	token, err := util.GenerateTokenPair(userData.Id, userData.Admin)
+	if err := banError(userData.Id, userData.Ban_Time_End); err != nil {
+		return nil, tokenPair, err
+	}
+	userData.Ban_Time_End.Valid = false //any ban left at this point has run out and been cleared
+
+	userData.Role, userData.Permissions, err = util.GetUserRole(userData.Id)
+	if err != nil {
+		return nil, tokenPair, fmt.Errorf("error getting user role: %v", err)
+	}
+
+	token, err := util.GenerateTokenPair(userData.Id, userData.Role, userData.Permissions)
 	if err != nil {
         return nil, tokenPair , fmt.Errorf("error generating token: %v", err)
     }
 	
 	return &userData, token, nil
 }
+
+//given the refresh token from the cookie, end its session so it can't be refreshed again
+func Logout(refreshToken string) error {
+	return util.RevokeRefreshSession(refreshToken)
+}
+
+//given a userId, end every session the user has. Returns how many sessions were still active.
+func RevokeUserSessions(userId int) (int, error) {
+	var exists bool
+	if err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userId).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	if !exists {
+		return 0, ErrorUserNotFound
+	}
+	return util.RevokeUserRefreshTokens(userId, util.RevokeAdmin)
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/scheduler"
+	"log"
+	"time"
+)
+
+// returned (wrapped, with the end of the ban) when a banned user tries to log in or reserve
+var ErrorUserBanned = errors.New("user is banned")
+
+// given a userId, return an error saying when the ban ends if the user is currently banned.
+// A ban that has already run out is cleared back to NULL.
+func checkUserBan(userId int) error {
+	var banTimeEnd sql.NullTime
+	err := database.DB.QueryRow("SELECT ban_time_end FROM users WHERE id = ?", userId).Scan(&banTimeEnd)
+	if err != nil {
+		return fmt.Errorf("error getting user ban time: %v", err)
+	}
+	return banError(userId, banTimeEnd)
+}
+
+// given a user's ban_time_end, return ErrorUserBanned (with the end time) if the ban is still running,
+// or clear the ban if it has run out
+func banError(userId int, banTimeEnd sql.NullTime) error {
+	if !banTimeEnd.Valid {
+		return nil
+	}
+	if banTimeEnd.Time.After(time.Now()) {
+		return fmt.Errorf("%w until %s", ErrorUserBanned, banTimeEnd.Time.Format("Jan 2, 2006 3:04 PM"))
+	}
+	if err := clearExpiredBan(userId); err != nil {
+		log.Printf("failed to clear expired ban of user %d: %v", userId, err)
+	}
+	return nil
+}
+
+// set a user's ban_time_end back to NULL if the ban has run out. Returns nil if there was nothing to clear.
+func clearExpiredBan(userId int) error {
+	//the ban is read and cleared in one transaction, so a ban an admin extends in the meantime isn't cleared. The
+	//end time is compared here rather than in SQL, ban_time_end isn't always stored in the same format.
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var banTimeEnd sql.NullTime
+	txErr = tx.QueryRow("SELECT ban_time_end FROM users WHERE id = ?", userId).Scan(&banTimeEnd)
+	if txErr == sql.ErrNoRows {
+		return nil
+	} else if txErr != nil {
+		return fmt.Errorf("error getting user ban time: %v", txErr)
+	}
+	if !banTimeEnd.Valid || banTimeEnd.Time.After(time.Now()) {
+		tx.Rollback() //nothing to clear
+		return nil
+	}
+
+	if _, txErr = tx.Exec("UPDATE users SET ban_time_end = NULL WHERE id = ?", userId); txErr != nil {
+		return fmt.Errorf("error clearing ban: %v", txErr)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing cleared ban: %v", err)
+	}
+	log.Printf("Cleared expired ban of user %d", userId)
+	return nil
+}
+
+// runs on startup (in main.go). Clears every ban that ran out while the API was offline, and makes sure
+// every running ban has a job to clear it when it ends. Returns how many bans were cleared.
+func ClearExpiredBans() (int, error) {
+	rows, err := database.DB.Query("SELECT id, ban_time_end FROM users WHERE ban_time_end IS NOT NULL")
+	if err != nil {
+		return 0, fmt.Errorf("error getting banned users: %v", err)
+	}
+
+	type ban struct {
+		userId int
+		end    time.Time
+	}
+	var bans []ban
+	for rows.Next() {
+		var b ban
+		if err := rows.Scan(&b.userId, &b.end); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning banned user: %v", err)
+		}
+		bans = append(bans, b)
+	}
+	rows.Close()
+
+	cleared := 0
+	for _, b := range bans {
+		if b.end.After(time.Now()) {
+			if _, err := scheduler.Schedule(scheduler.JobClearBan, b.userId, b.end); err != nil {
+				log.Printf("failed to schedule ban clear for user %d: %v", b.userId, err)
+			}
+			continue
+		}
+		if err := clearExpiredBan(b.userId); err != nil {
+			return cleared, err
+		}
+		cleared++
+	}
+	return cleared, nil
+}
+
+// clears a user's ban once it ends
+func clearBanJob(job models.Job) error {
+	return clearExpiredBan(job.ReferenceId)
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/scheduler"
+	"gin-api/util"
+	"log"
+	"time"
+)
+
+// define reusable check-in errors
+var (
+	ErrorInvalidCardScan  = errors.New("invalid card scan")
+	ErrorNothingToCheckIn = errors.New("no running reservation to check in to")
+)
+
+type CheckInRequest struct {
+	Scanner_Message string `json:"scanner_message"`
+}
+
+// given the card scanned at the kiosk, check its user in to every running reservation they haven't checked in to
+// yet so none of them are released as no-shows. Returns the ids of the reservations checked in to.
+func CheckIn(request CheckInRequest) ([]int, error) {
+	cardData, err := util.ParseScannerString(request.Scanner_Message)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCardScan, err)
+	}
+	userId, err := resolveCredential(database.DB, cardData)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.Query("SELECT id FROM reservations WHERE userId = ? AND is_active = TRUE AND checked_in_at IS NULL", userId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting reservations to check in to: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning reservation: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		log.Printf("Rejected %s card scan at check-in: user %d has no running reservation to check in to", cardData.Format, userId)
+		return nil, fmt.Errorf("%w for user %d", ErrorNothingToCheckIn, userId)
+	}
+
+	checkedIn := []int{}
+	now := time.Now()
+	for _, id := range ids {
+		// the deadline job may have released the reservation since it was looked up
+		result, err := database.DB.Exec("UPDATE reservations SET checked_in_at = ? WHERE id = ? AND is_active = TRUE AND checked_in_at IS NULL", now, id)
+		if err != nil {
+			return nil, fmt.Errorf("error checking in to reservation %d: %v", id, err)
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+			continue
+		}
+		if err := scheduler.Cancel(scheduler.JobCheckInDeadline, id); err != nil {
+			log.Printf("failed to cancel check-in deadline of reservation %d: %v", id, err)
+		}
+		checkedIn = append(checkedIn, id)
+	}
+	if len(checkedIn) == 0 {
+		return nil, fmt.Errorf("%w for user %d", ErrorNothingToCheckIn, userId)
+	}
+
+	log.Printf("User %d checked in to reservation(s) %v", userId, checkedIn)
+	return checkedIn, nil
+}
+
+// given a reservation that just started and when it started, schedule the job that releases it if its user
+// doesn't check in within the check-in window. Does nothing when check-in is turned off, and reservations that
+// weren't charged (EGN blocks and series occurrences) don't need a check-in. A late start (the API was down at
+// time_reserved) gets the full window from when it actually started.
+func scheduleCheckInDeadline(reservationId int, startedAt time.Time) {
+	minutes := util.Settings.PrinterSettings.CheckInMinutes
+	if minutes <= 0 {
+		return
+	}
+	uncharged, err := isUnchargedReservation(reservationId)
+	if err != nil {
+		log.Printf("failed to schedule check-in deadline of reservation %d: %v", reservationId, err)
+		return
+	}
+	if uncharged {
+		return
+	}
+
+	if startedAt.Before(time.Now()) {
+		startedAt = time.Now()
+	}
+	if _, err := scheduler.Schedule(scheduler.JobCheckInDeadline, reservationId, startedAt.Add(time.Duration(minutes)*time.Minute)); err != nil {
+		log.Printf("failed to schedule check-in deadline of reservation %d: %v", reservationId, err)
+	}
+}
+
+// releases a reservation whose user didn't check in before the deadline. Reservations that were checked in to,
+// already ended or weren't charged are left alone.
+func checkInDeadlineJob(job models.Job) error {
+	return releaseNoShow(job.ReferenceId)
+}
+
+// given a running reservation nobody checked in to, end it as a no-show, refund the user per the no_show refund
+// policy and add to the user's no-show count
+func releaseNoShow(reservationId int) error {
+	var r models.Reservation
+	var checkedIn, uncharged bool
+	err := database.DB.QueryRow(`SELECT id, printerid, userId, time_reserved, time_complete, is_active, checked_in_at IS NOT NULL,
+								is_egn_reservation OR series_id IS NOT NULL FROM reservations WHERE id = ?`, reservationId).Scan(
+		&r.Id, &r.PrinterId, &r.UserId, &r.Time_Reserved, &r.Time_Complete, &r.Is_Active, &checkedIn, &uncharged)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error getting reservation %d: %v", reservationId, err)
+	}
+	if !r.Is_Active || checkedIn || uncharged {
+		return nil
+	}
+
+	policy, err := getRefundPolicy(EndReasonNoShow)
+	if err != nil && !errors.Is(err, ErrorUnknownReasonCode) {
+		return err
+	}
+	refund := 0
+	if policy != nil {
+		refund = refundMinutes(policy, r.Time_Reserved, r.Time_Complete)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// only release it if the user didn't check in (and nothing ended it) in the meantime
+	result, err := tx.Exec(`UPDATE reservations SET is_active = FALSE, outcome = ?, end_reason = ?, refunded_minutes = ?, ended_at = ?
+						WHERE id = ? AND is_active = TRUE AND checked_in_at IS NULL`, OutcomeNoShow, EndReasonNoShow, refund, time.Now(), r.Id)
+	if err != nil {
+		txErr = err
+		return fmt.Errorf("error releasing reservation %d: %v", r.Id, err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		tx.Rollback() //checked in to or ended, nothing to release
+		return nil
+	}
+
+	if _, txErr = tx.Exec("UPDATE users SET no_show_count = no_show_count + 1 WHERE id = ?", r.UserId); txErr != nil {
+		return fmt.Errorf("error updating user %d for no-show: %v", r.UserId, txErr)
+	}
+	if refund > 0 {
+		txErr = recordMinuteChange(tx, minuteChange{userId: r.UserId, delta: refund, entryType: LedgerRefund,
+			reservationId: r.Id, note: "released after a no-show"})
+		if txErr != nil {
+			return fmt.Errorf("error refunding weekly minutes to user: %v", txErr)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	releaseReservationPrinter(r.PrinterId, r.Id)
+	log.Printf("Reservation %d released, user %d never checked in. Refunded %d minutes", r.Id, r.UserId, refund)
+	return nil
+}
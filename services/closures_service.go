@@ -0,0 +1,328 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/util"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// returned (wrapped, with when and why) when a reservation would run into a closure or outside operating hours
+var ErrorLabClosed = errors.New("the lab is closed")
+
+// a stretch of time the lab is closed, either from a closure or outside the day's operating hours
+type closedPeriod struct {
+	start  time.Time
+	end    time.Time
+	reason string
+}
+
+type CreateClosureRequest struct {
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Reason   string    `json:"reason"`
+}
+
+// given a start, end and reason, add a closure. Reservations can't be made into a closure once it exists,
+// but reservations already booked into it are left for the admin to cancel.
+func CreateClosure(request CreateClosureRequest) (*models.Closure, error) {
+	if !request.EndsAt.After(request.StartsAt) {
+		return nil, fmt.Errorf("closure must end after it starts")
+	}
+	if !request.EndsAt.After(time.Now()) {
+		return nil, fmt.Errorf("closure has already ended")
+	}
+
+	result, err := database.DB.Exec("INSERT INTO closures (starts_at, ends_at, reason) VALUES (?, ?, ?)",
+		request.StartsAt, request.EndsAt, strings.TrimSpace(request.Reason))
+	if err != nil {
+		return nil, fmt.Errorf("error inserting closure: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting closure id: %v", err)
+	}
+
+	var closure models.Closure
+	err = database.DB.QueryRow("SELECT id, starts_at, ends_at, reason, created_at FROM closures WHERE id = ?", id).Scan(
+		&closure.Id, &closure.Starts_At, &closure.Ends_At, &closure.Reason, &closure.Created_At)
+	if err != nil {
+		return nil, fmt.Errorf("error getting new closure: %v", err)
+	}
+
+	if booked, err := countReservationsBetween(closure.Starts_At, closure.Ends_At); err != nil {
+		log.Printf("failed to check reservations during closure %d: %v", closure.Id, err)
+	} else if booked > 0 {
+		log.Printf("Closure %d overlaps %d active or upcoming reservation(s)", closure.Id, booked)
+	}
+	return &closure, nil
+}
+
+// given a closureId, remove that closure
+func DeleteClosure(closureId int) error {
+	result, err := database.DB.Exec("DELETE FROM closures WHERE id = ?", closureId)
+	if err != nil {
+		return fmt.Errorf("error deleting closure: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		return fmt.Errorf("closure with id %d not found", closureId)
+	}
+	return nil
+}
+
+// returns every closure that hasn't ended yet, soonest first
+func GetUpcomingClosures() ([]models.Closure, error) {
+	rows, err := database.DB.Query("SELECT id, starts_at, ends_at, reason, created_at FROM closures")
+	if err != nil {
+		return nil, fmt.Errorf("error getting closures: %v", err)
+	}
+	defer rows.Close()
+
+	//compared here rather than in SQL since stored timestamps may carry different UTC offsets
+	now := time.Now()
+	closures := []models.Closure{}
+	for rows.Next() {
+		var c models.Closure
+		if err := rows.Scan(&c.Id, &c.Starts_At, &c.Ends_At, &c.Reason, &c.Created_At); err != nil {
+			return nil, fmt.Errorf("error scanning closure: %v", err)
+		}
+		if c.Ends_At.After(now) {
+			closures = append(closures, c)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+
+	sort.Slice(closures, func(i, j int) bool {
+		return closures[i].Starts_At.Before(closures[j].Starts_At)
+	})
+	return closures, nil
+}
+
+// returns the lab's weekly operating hours, Sunday first. Days that aren't listed are open all day.
+func GetOperatingHours() ([]models.OperatingHours, error) {
+	rows, err := database.DB.Query("SELECT weekday, open_time, close_time, is_closed FROM operating_hours ORDER BY weekday ASC")
+	if err != nil {
+		return nil, fmt.Errorf("error getting operating hours: %v", err)
+	}
+	defer rows.Close()
+
+	hours := []models.OperatingHours{}
+	for rows.Next() {
+		var h models.OperatingHours
+		if err := rows.Scan(&h.Weekday, &h.Open_Time, &h.Close_Time, &h.Is_Closed); err != nil {
+			return nil, fmt.Errorf("error scanning operating hours: %v", err)
+		}
+		hours = append(hours, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return hours, nil
+}
+
+// given the hours for each day of the week, replace the lab's operating hours. Days left out are open all day,
+// so an empty list removes the operating hours entirely.
+func SetOperatingHours(request []models.OperatingHours) error {
+	seen := make(map[int]bool)
+	for _, h := range request {
+		if h.Weekday < 0 || h.Weekday > 6 {
+			return fmt.Errorf("weekday must be between 0 (Sunday) and 6 (Saturday)")
+		}
+		if seen[h.Weekday] {
+			return fmt.Errorf("weekday %d is listed more than once", h.Weekday)
+		}
+		seen[h.Weekday] = true
+		if h.Is_Closed {
+			continue
+		}
+		if _, _, err := parseOperatingHours(h); err != nil {
+			return err
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, txErr = tx.Exec("DELETE FROM operating_hours"); txErr != nil {
+		return fmt.Errorf("error clearing operating hours: %v", txErr)
+	}
+	for _, h := range request {
+		openTime, closeTime := h.Open_Time, h.Close_Time
+		if h.Is_Closed {
+			openTime, closeTime = "00:00", "24:00"
+		}
+		_, txErr = tx.Exec("INSERT INTO operating_hours (weekday, open_time, close_time, is_closed) VALUES (?, ?, ?, ?)",
+			h.Weekday, openTime, closeTime, h.Is_Closed)
+		if txErr != nil {
+			return fmt.Errorf("error setting operating hours for weekday %d: %v", h.Weekday, txErr)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// given a day's operating hours, return its open and close times as minutes after midnight.
+// The close time may be "24:00" for a lab that stays open until midnight.
+func parseOperatingHours(h models.OperatingHours) (int, int, error) {
+	openMinutes, err := parseClockTime(h.Open_Time)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid open_time for weekday %d: %v", h.Weekday, err)
+	}
+	closeMinutes := 24 * 60
+	if strings.TrimSpace(h.Close_Time) != "24:00" {
+		closeMinutes, err = parseClockTime(h.Close_Time)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid close_time for weekday %d: %v", h.Weekday, err)
+		}
+	}
+	if openMinutes >= closeMinutes {
+		return 0, 0, fmt.Errorf("open_time (%s) must be earlier than close_time (%s) for weekday %d", h.Open_Time, h.Close_Time, h.Weekday)
+	}
+	return openMinutes, closeMinutes, nil
+}
+
+// given a time window, return the earliest closed period that overlaps it, or nil if the lab is open the whole
+// time. The period's start is clamped to the window's start.
+func firstClosureBetween(start time.Time, end time.Time) (*closedPeriod, error) {
+	periods, err := closedPeriodsBetween(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var first *closedPeriod
+	for i := range periods {
+		p := periods[i]
+		if !(p.start.Before(end) && start.Before(p.end)) {
+			continue
+		}
+		if p.start.Before(start) {
+			p.start = start
+		}
+		if first == nil || p.start.Before(first.start) {
+			first = &p
+		}
+	}
+	return first, nil
+}
+
+// return the closures and the time outside operating hours from the start of start's day until end
+func closedPeriodsBetween(start time.Time, end time.Time) ([]closedPeriod, error) {
+	var periods []closedPeriod
+
+	rows, err := database.DB.Query("SELECT starts_at, ends_at, reason FROM closures")
+	if err != nil {
+		return nil, fmt.Errorf("error getting closures: %v", err)
+	}
+	for rows.Next() {
+		var p closedPeriod
+		if err := rows.Scan(&p.start, &p.end, &p.reason); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning closure: %v", err)
+		}
+		if p.reason == "" {
+			p.reason = "closure"
+		}
+		periods = append(periods, p)
+	}
+	rows.Close()
+
+	hours, err := GetOperatingHours()
+	if err != nil {
+		return nil, err
+	}
+	hoursByDay := make(map[time.Weekday]models.OperatingHours)
+	for _, h := range hours {
+		hoursByDay[time.Weekday(h.Weekday)] = h
+	}
+	if len(hoursByDay) == 0 {
+		return periods, nil
+	}
+
+	loc := util.LabLocation()
+	local := start.In(loc)
+	for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc); day.Before(end); day = day.AddDate(0, 0, 1) {
+		h, ok := hoursByDay[day.Weekday()]
+		if !ok {
+			continue
+		}
+		nextDay := day.AddDate(0, 0, 1)
+		if h.Is_Closed {
+			periods = append(periods, closedPeriod{day, nextDay, "closed on " + day.Weekday().String() + "s"})
+			continue
+		}
+		openMinutes, closeMinutes, err := parseOperatingHours(h)
+		if err != nil {
+			return nil, err
+		}
+		opens := clockTimeOn(day, openMinutes, loc)
+		closes := clockTimeOn(day, closeMinutes, loc)
+		if closeMinutes == 24*60 {
+			closes = nextDay
+		}
+		if opens.After(day) {
+			periods = append(periods, closedPeriod{day, opens, "opens at " + h.Open_Time})
+		}
+		if closes.Before(nextDay) {
+			periods = append(periods, closedPeriod{closes, nextDay, "closes at " + h.Close_Time})
+		}
+	}
+	return periods, nil
+}
+
+// given a time window, return an error if the lab is closed at any point in it
+func checkLabOpen(start time.Time, end time.Time) error {
+	closed, err := firstClosureBetween(start, end)
+	if err != nil {
+		return err
+	}
+	if closed != nil {
+		return closedError(closed)
+	}
+	return nil
+}
+
+// describe a closed period as an ErrorLabClosed
+func closedError(closed *closedPeriod) error {
+	loc := util.LabLocation()
+	return fmt.Errorf("%w from %s to %s (%s)", ErrorLabClosed, closed.start.In(loc).Format("Mon Jan 2 3:04 PM"),
+		closed.end.In(loc).Format("Mon Jan 2 3:04 PM"), closed.reason)
+}
+
+// given a time window, return how many active or upcoming reservations overlap it
+func countReservationsBetween(start time.Time, end time.Time) (int, error) {
+	rows, err := database.DB.Query("SELECT time_reserved, time_complete FROM reservations WHERE is_active = TRUE OR is_scheduled = TRUE")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var bookedStart, bookedEnd time.Time
+		if err := rows.Scan(&bookedStart, &bookedEnd); err != nil {
+			return 0, err
+		}
+		if start.Before(bookedEnd) && bookedStart.Before(end) {
+			count++
+		}
+	}
+	return count, rows.Err()
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/util"
+	"log"
+	"strings"
+	"time"
+)
+
+// define reusable credential errors
+var (
+	ErrorCredentialNotFound = errors.New("credential not found")
+	ErrorCredentialInUse    = errors.New("card is already assigned")
+	ErrorCredentialRevoked  = errors.New("credential has been revoked")
+)
+
+type CredentialRequest struct {
+	Scanner_Message string `json:"scanner_message"`
+	Label           string `json:"label"` // optional, e.g. student card or key fob
+}
+
+// given a parsed card scan, return the id of the user its credential belongs to. Scans of unknown cards return
+// ErrorUserNotFound and scans of revoked cards ErrorCredentialRevoked, both are logged.
+func resolveCredential(db dbExecutor, cardData *util.ParsedCardData) (int, error) {
+	var userId int
+	var revoked bool
+	//an active credential wins over revoked ones, a card can have been revoked for one user and given to another
+	err := db.QueryRow(`SELECT user_id, revoked_at IS NOT NULL FROM credentials WHERE key_type = ? AND credential_key = ?
+						ORDER BY revoked_at IS NULL DESC, id DESC LIMIT 1`, cardData.KeyType, cardData.Key).Scan(&userId, &revoked)
+	if err == sql.ErrNoRows {
+		log.Printf("Rejected %s card scan: no user has the card", cardData.Format)
+		return 0, ErrorUserNotFound
+	} else if err != nil {
+		return 0, fmt.Errorf("error getting credential: %v", err)
+	}
+	if revoked {
+		log.Printf("Rejected %s card scan: the card of user %d has been revoked", cardData.Format, userId)
+		return 0, ErrorCredentialRevoked
+	}
+	return userId, nil
+}
+
+// given the raw scanner output, return the user the card belongs to
+func GetUserByCard(scannerMessage string) (*models.UserData, error) {
+	cardData, err := util.ParseScannerString(scannerMessage)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCardScan, err)
+	}
+	userId, err := resolveCredential(database.DB, cardData)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserById(userId)
+}
+
+// given a userId, return every credential the user has had, active ones first
+func GetUserCredentials(userId int) ([]models.Credential, error) {
+	if err := checkUserExists(userId); err != nil {
+		return nil, err
+	}
+	rows, err := database.DB.Query(`SELECT id, user_id, key_type, credential_key, label, created_by, created_at, revoked_by, revoked_at, replaced_by
+									FROM credentials WHERE user_id = ? ORDER BY revoked_at IS NULL DESC, id DESC`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting credentials: %v", err)
+	}
+	defer rows.Close()
+
+	credentials := []models.Credential{}
+	for rows.Next() {
+		var c models.Credential
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&c.Id, &c.User_Id, &c.Key_Type, &c.Key, &c.Label, &c.Created_By, &c.Created_At, &c.Revoked_By, &revokedAt, &c.Replaced_By); err != nil {
+			return nil, fmt.Errorf("error scanning credential: %v", err)
+		}
+		if revokedAt.Valid {
+			c.Revoked_At = &revokedAt.Time
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, nil
+}
+
+// given a userId, the scan of a card and the admin adding it, give the user another card to log in with
+func AddCredential(userId int, request CredentialRequest, adminId int) (*models.Credential, error) {
+	cardData, err := util.ParseScannerString(request.Scanner_Message)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCardScan, err)
+	}
+	if err := checkUserExists(userId); err != nil {
+		return nil, err
+	}
+
+	id, err := insertCredential(database.DB, userId, cardData, request.Label, adminId)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Admin %d added %s credential %d to user %d", adminId, cardData.KeyType, id, userId)
+	return getCredential(database.DB, id)
+}
+
+// given a credentialId and the admin revoking it, stop the card from logging in. The user keeps their account
+// and any other cards.
+func RevokeCredential(credentialId int, adminId int) error {
+	if err := revokeCredential(database.DB, credentialId, adminId); err != nil {
+		return err
+	}
+	log.Printf("Admin %d revoked credential %d", adminId, credentialId)
+	return nil
+}
+
+// given a credentialId, the scan of the new card and the admin replacing it, revoke the old card and give its
+// user the new one in its place. The new card keeps the old one's label unless a new label is given.
+func ReplaceCredential(credentialId int, request CredentialRequest, adminId int) (*models.Credential, error) {
+	cardData, err := util.ParseScannerString(request.Scanner_Message)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCardScan, err)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	old, txErr := getCredential(tx, credentialId)
+	if txErr != nil {
+		return nil, txErr
+	}
+	if old.Revoked_At != nil {
+		txErr = fmt.Errorf("%w: credential %d", ErrorCredentialRevoked, credentialId)
+		return nil, txErr
+	}
+	label := request.Label
+	if strings.TrimSpace(label) == "" {
+		label = old.Label
+	}
+
+	//the old card is revoked first so a card can be replaced with a re-issue of the same number
+	if txErr = revokeCredential(tx, credentialId, adminId); txErr != nil {
+		return nil, txErr
+	}
+	newId, txErr := insertCredential(tx, old.User_Id, cardData, label, adminId)
+	if txErr != nil {
+		return nil, txErr
+	}
+	if _, txErr = tx.Exec("UPDATE credentials SET replaced_by = ? WHERE id = ?", newId, credentialId); txErr != nil {
+		txErr = fmt.Errorf("error linking replaced credential: %v", txErr)
+		return nil, txErr
+	}
+
+	if txErr = tx.Commit(); txErr != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", txErr)
+	}
+	log.Printf("Admin %d replaced credential %d of user %d with credential %d", adminId, credentialId, old.User_Id, newId)
+	return getCredential(database.DB, newId)
+}
+
+// given a credentialId, return the credential
+func getCredential(db dbExecutor, credentialId int) (*models.Credential, error) {
+	var c models.Credential
+	var revokedAt sql.NullTime
+	err := db.QueryRow(`SELECT id, user_id, key_type, credential_key, label, created_by, created_at, revoked_by, revoked_at, replaced_by
+						FROM credentials WHERE id = ?`, credentialId).Scan(
+		&c.Id, &c.User_Id, &c.Key_Type, &c.Key, &c.Label, &c.Created_By, &c.Created_At, &c.Revoked_By, &revokedAt, &c.Replaced_By)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrorCredentialNotFound, credentialId)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting credential: %v", err)
+	}
+	if revokedAt.Valid {
+		c.Revoked_At = &revokedAt.Time
+	}
+	return &c, nil
+}
+
+// given a userId, a parsed card scan, a label and who is adding it, add the card as one of the user's
+// credentials. Returns the new credential's id, or ErrorCredentialInUse if the card already has a user.
+func insertCredential(db dbExecutor, userId int, cardData *util.ParsedCardData, label string, createdBy int) (int, error) {
+	var owner int
+	err := db.QueryRow("SELECT user_id FROM credentials WHERE key_type = ? AND credential_key = ? AND revoked_at IS NULL",
+		cardData.KeyType, cardData.Key).Scan(&owner)
+	if err == nil {
+		return 0, fmt.Errorf("%w to user %d", ErrorCredentialInUse, owner)
+	} else if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("error checking credential: %v", err)
+	}
+
+	if label = strings.TrimSpace(label); label == "" {
+		label = cardData.Format
+	}
+	result, err := db.Exec("INSERT INTO credentials (user_id, key_type, credential_key, label, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userId, cardData.KeyType, cardData.Key, label, createdBy, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("error adding credential: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error getting credential id: %v", err)
+	}
+	return int(id), nil
+}
+
+// given a credentialId and who is revoking it, revoke the credential
+func revokeCredential(db dbExecutor, credentialId int, revokedBy int) error {
+	result, err := db.Exec("UPDATE credentials SET revoked_at = ?, revoked_by = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now(), revokedBy, credentialId)
+	if err != nil {
+		return fmt.Errorf("error revoking credential: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		//either there is no such credential or it was already revoked
+		if _, err := getCredential(db, credentialId); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: credential %d", ErrorCredentialRevoked, credentialId)
+	}
+	return nil
+}
+
+// given a userId, return ErrorUserNotFound if there is no such user
+func checkUserExists(userId int) error {
+	var exists bool
+	if err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userId).Scan(&exists); err != nil {
+		return fmt.Errorf("error getting user: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %d", ErrorUserNotFound, userId)
+	}
+	return nil
+}
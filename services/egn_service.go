@@ -0,0 +1,242 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/util"
+	"log"
+	"time"
+)
+
+// returned when an EGN block includes a printer that isn't an EGN printer
+var ErrorNotEgnPrinter = errors.New("printer is not an EGN printer")
+
+type BookEgnBlockRequest struct {
+	PrinterIds []int      `json:"printer_ids"`
+	TimeMins   int        `json:"time_mins"`
+	StartTime  *time.Time `json:"start_time"` // optional, leave empty to start the block now
+}
+
+// given a set of EGN printers and a class period, reserve every printer for the period as one EGN block booking.
+// Block bookings are made under the given (admin) user, are not charged to anyone's weekly_minutes and skip
+// the per-user print limits. Either every printer is booked or none of them are. Returns the new reservation ids.
+func BookEgnBlock(request BookEgnBlockRequest, userId int) ([]int, error) {
+	if len(request.PrinterIds) == 0 {
+		return nil, fmt.Errorf("at least one printer is required")
+	}
+	if request.TimeMins <= 0 {
+		return nil, fmt.Errorf("block length must be a positive number of minutes")
+	}
+
+	now := time.Now()
+	timeReserved := now
+	scheduled := false
+	if request.StartTime != nil {
+		if request.StartTime.Before(now.Add(-scheduleTolerance)) {
+			return nil, fmt.Errorf("block start time %s is in the past", request.StartTime.Format(time.RFC3339))
+		}
+		if request.StartTime.After(now.Add(scheduleTolerance)) {
+			timeReserved = *request.StartTime
+			scheduled = true
+		}
+	}
+	timeComplete := timeReserved.Add(time.Duration(request.TimeMins) * time.Minute)
+
+	var username string
+	if err := database.DB.QueryRow("SELECT username FROM users WHERE id = ?", userId).Scan(&username); err != nil {
+		return nil, fmt.Errorf("failed to get username: %v", err)
+	}
+
+	// check every printer before booking any of them
+	seen := make(map[int]bool)
+	for _, printerId := range request.PrinterIds {
+		if seen[printerId] {
+			return nil, fmt.Errorf("printer %d is listed more than once", printerId)
+		}
+		seen[printerId] = true
+
+		var isEgnPrinter, inUse bool
+		var status string
+		err := database.DB.QueryRow("SELECT is_egn_printer, in_use, status FROM printers WHERE id = ?", printerId).Scan(&isEgnPrinter, &inUse, &status)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("printer with id %d not found", printerId)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get printer details: %v", err)
+		}
+		if !isEgnPrinter {
+			return nil, fmt.Errorf("%w: printer %d", ErrorNotEgnPrinter, printerId)
+		}
+		if isOutOfService(status) {
+			return nil, fmt.Errorf("%w: printer %d status is %s", ErrorPrinterUnavailable, printerId, status)
+		}
+		if !scheduled && inUse {
+			return nil, fmt.Errorf("printer %d is already in use", printerId)
+		}
+		if err := checkPrinterAvailability(database.DB, printerId, timeReserved, timeComplete, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	reservations := make([]models.Reservation, 0, len(request.PrinterIds))
+	for _, printerId := range request.PrinterIds {
+		if !scheduled {
+			if _, txErr = tx.Exec("UPDATE printers SET in_use = TRUE, last_reserved_by = ? WHERE id = ?", username, printerId); txErr != nil {
+				return nil, fmt.Errorf("failed to update printer %d: %v", printerId, txErr)
+			}
+			if txErr = setPrinterStatus(tx, printerId, PrinterReserved, "EGN block booked by "+username, 0); txErr != nil {
+				return nil, txErr
+			}
+		}
+
+		result, err := tx.Exec(
+			"INSERT INTO reservations (printerid, userid, time_reserved, time_complete, is_active, is_scheduled, is_egn_reservation) values (?, ?, ?, ?, ?, ?, TRUE)",
+			printerId, userId, timeReserved, timeComplete, !scheduled, scheduled)
+		if err != nil {
+			txErr = err
+			return nil, fmt.Errorf("failed to insert reservation for printer %d: %v", printerId, err)
+		}
+		reservationId, err := result.LastInsertId()
+		if err != nil {
+			txErr = err
+			return nil, fmt.Errorf("failed to get reservation id: %v", err)
+		}
+
+		reservations = append(reservations, models.Reservation{
+			Id:                 int(reservationId),
+			PrinterId:          printerId,
+			UserId:             userId,
+			Time_Reserved:      timeReserved,
+			Time_Complete:      timeComplete,
+			Is_Active:          !scheduled,
+			Is_Scheduled:       scheduled,
+			Is_Egn_Reservation: true,
+		})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	// the block is in the database now, so recovery picks up anything that fails from here on
+	ids := make([]int, 0, len(reservations))
+	for _, reservation := range reservations {
+		ids = append(ids, reservation.Id)
+		if scheduled {
+			if err := trackUpcomingReservation(reservation); err != nil {
+				log.Printf("CRITICAL: failed to schedule start of EGN reservation %d: %v", reservation.Id, err)
+			}
+			continue
+		}
+		if _, err := util.TurnOnPrinter(reservation.PrinterId); err != nil {
+			log.Printf("CRITICAL: failed to turn on printer %d for EGN reservation %d: %v", reservation.PrinterId, reservation.Id, err)
+		}
+		if err := trackActiveReservation(reservation); err != nil {
+			log.Printf("CRITICAL: failed to schedule completion of EGN reservation %d: %v", reservation.Id, err)
+		}
+	}
+
+	log.Printf("Booked EGN block of %d printer(s) from %s to %s", len(ids), timeReserved.Format(time.RFC3339), timeComplete.Format(time.RFC3339))
+	return ids, nil
+}
+
+// returns all active and upcoming EGN block reservations, soonest first. These are left out of GetActiveReservations.
+func GetEgnReservations() ([]models.ReservationDTO, error) {
+	query := `
+		SELECT 
+			r.id, r.printerId, p.name AS printer_name, r.userId, u.username, 
+			r.time_reserved, r.time_complete, r.is_active, r.is_scheduled, r.is_egn_reservation
+		FROM reservations r
+		JOIN printers p ON r.printerId = p.id
+		JOIN users u ON r.userId = u.id
+		WHERE r.is_egn_reservation = 1 AND (r.is_active = 1 OR r.is_scheduled = 1)
+		ORDER BY r.time_reserved ASC
+	`
+	rows, err := database.DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var reservations []models.ReservationDTO
+	for rows.Next() {
+		var r models.ReservationDTO
+		if err := rows.Scan(
+			&r.Id, &r.PrinterId, &r.PrinterName, &r.UserId, &r.Username,
+			&r.Time_Reserved, &r.Time_Complete, &r.Is_Active, &r.Is_Scheduled, &r.Is_Egn_Reservation,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		reservations = append(reservations, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return reservations, nil
+}
+
+// given a list of printers and a userId, drop the printers that are in an EGN block right now unless the
+// user is in the EGN lab (or can book EGN blocks)
+func hideEgnBlockedPrinters(printers []models.Printer, userId int) ([]models.Printer, error) {
+	var isEgnLab bool
+	err := database.DB.QueryRow("SELECT is_egn_lab FROM users WHERE id = ?", userId).Scan(&isEgnLab)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error getting user EGN lab access: %v", err)
+	}
+	if isEgnLab {
+		return printers, nil
+	}
+	if _, permissions, err := util.GetUserRole(userId); err == nil {
+		for _, permission := range permissions {
+			if permission == models.PermBookBlocks {
+				return printers, nil
+			}
+		}
+	}
+
+	rows, err := database.DB.Query(`SELECT printerid, time_reserved, time_complete FROM reservations
+									WHERE is_egn_reservation = TRUE AND (is_active = TRUE OR is_scheduled = TRUE)`)
+	if err != nil {
+		return nil, fmt.Errorf("error getting EGN blocks: %v", err)
+	}
+	defer rows.Close()
+
+	//compared here rather than in SQL since stored timestamps may carry different UTC offsets
+	now := time.Now()
+	blocked := make(map[int]bool)
+	for rows.Next() {
+		var printerId int
+		var start, end time.Time
+		if err := rows.Scan(&printerId, &start, &end); err != nil {
+			return nil, fmt.Errorf("error scanning EGN block: %v", err)
+		}
+		if !now.Before(start) && now.Before(end) {
+			blocked[printerId] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+
+	visible := make([]models.Printer, 0, len(printers))
+	for _, p := range printers {
+		if !blocked[p.Id] {
+			visible = append(visible, p)
+		}
+	}
+	return visible, nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/scheduler"
+	"log"
+	"time"
+)
+
+// registers the handlers for every job type with the scheduler. Called from main.go before scheduler.Start.
+func RegisterJobHandlers() {
+	scheduler.RegisterHandler(scheduler.JobStartReservation, startReservationJob)
+	scheduler.RegisterHandler(scheduler.JobCompleteReservation, completeReservationJob)
+	scheduler.RegisterHandler(scheduler.JobWarnUser, warnUserJob)
+	scheduler.RegisterHandler(scheduler.JobWeeklyReset, weeklyResetJob)
+	scheduler.RegisterHandler(scheduler.JobExpireWaitlistOffer, expireWaitlistOfferJob)
+	scheduler.RegisterHandler(scheduler.JobClearBan, clearBanJob)
+	scheduler.RegisterHandler(scheduler.JobCheckInDeadline, checkInDeadlineJob)
+}
+
+// return every job that hasn't run yet, soonest first
+func GetPendingJobs() ([]models.Job, error) {
+	return scheduler.GetPendingJobs()
+}
+
+// starts an upcoming reservation once its window begins
+func startReservationJob(job models.Job) error {
+	return StartScheduledReservation(job.ReferenceId)
+}
+
+// completes a reservation at its time_complete. Reservations that already ended (cancelled, or completed
+// by an earlier run of this job) are left alone.
+func completeReservationJob(job models.Job) error {
+	var printerId int
+	var isActive bool
+	err := database.DB.QueryRow("SELECT printerid, is_active FROM reservations WHERE id = ?", job.ReferenceId).Scan(&printerId, &isActive)
+	if err == sql.ErrNoRows || (err == nil && !isActive) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error getting reservation %d: %v", job.ReferenceId, err)
+	}
+
+	CompleteReservation(printerId, job.ReferenceId)
+
+	//CompleteReservation only logs its errors, so check that it actually ended the reservation
+	if err := database.DB.QueryRow("SELECT is_active FROM reservations WHERE id = ?", job.ReferenceId).Scan(&isActive); err != nil {
+		return fmt.Errorf("error checking reservation %d after completion: %v", job.ReferenceId, err)
+	}
+	if isActive {
+		return fmt.Errorf("reservation %d is still active after completion", job.ReferenceId)
+	}
+	return nil
+}
+
+// warns that a reservation is about to end. There is no way to reach the user outside of the kiosk yet,
+// so the warning is logged for lab staff.
+func warnUserJob(job models.Job) error {
+	var printerId int
+	var username string
+	var isActive bool
+	var timeComplete time.Time
+	querySQL := `SELECT r.printerid, u.username, r.is_active, r.time_complete
+				FROM reservations r
+				JOIN users u ON r.userId = u.id
+				WHERE r.id = ?`
+	err := database.DB.QueryRow(querySQL, job.ReferenceId).Scan(&printerId, &username, &isActive, &timeComplete)
+	if err == sql.ErrNoRows || (err == nil && !isActive) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error getting reservation %d: %v", job.ReferenceId, err)
+	}
+
+	log.Printf("Reservation %d for %s on printer %d ends in %d minute(s)", job.ReferenceId, username, printerId,
+		int(time.Until(timeComplete).Minutes()))
+	return nil
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"log"
+)
+
+// entry types stored in minute_ledger.entry_type
+const (
+	LedgerDebit       = "debit"        // minutes charged for a reservation or an extension
+	LedgerRefund      = "refund"       // minutes given back for a cancelled, failed or released reservation
+	LedgerGrant       = "grant"        // minutes added (or taken) by an admin
+	LedgerWeeklyReset = "weekly_reset" // balance set back to the weekly default
+	LedgerOpening     = "opening"      // balance a user had when their ledger was started
+)
+
+// a change to a user's weekly minutes along with what caused it. A reservationId or actorId of 0 is stored as NULL.
+type minuteChange struct {
+	userId        int
+	delta         int
+	entryType     string
+	reservationId int
+	actorId       int
+	note          string
+}
+
+// given a change, apply it to the user's weekly_minutes and add it to the ledger. Debits are only applied if the
+// user has enough minutes left, otherwise ErrorInsufficientMinutes is returned and nothing changes. Pass a
+// transaction as db so the change commits (or rolls back) with the rest of the caller's work.
+func recordMinuteChange(db dbExecutor, change minuteChange) error {
+	updateSQL := "UPDATE users SET weekly_minutes = weekly_minutes + ? WHERE id = ?"
+	args := []interface{}{change.delta, change.userId}
+	if change.entryType == LedgerDebit {
+		updateSQL += " AND weekly_minutes + ? >= 0"
+		args = append(args, change.delta)
+	}
+	result, err := db.Exec(updateSQL, args...)
+	if err != nil {
+		return fmt.Errorf("error updating weekly minutes of user %d: %v", change.userId, err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		if change.entryType == LedgerDebit {
+			return fmt.Errorf("%w: user %d can't be charged %d minutes", ErrorInsufficientMinutes, change.userId, -change.delta)
+		}
+		return fmt.Errorf("user %d not found", change.userId)
+	}
+
+	var balance int
+	if err := db.QueryRow("SELECT weekly_minutes FROM users WHERE id = ?", change.userId).Scan(&balance); err != nil {
+		return fmt.Errorf("error getting weekly minutes of user %d: %v", change.userId, err)
+	}
+	_, err = db.Exec(`INSERT INTO minute_ledger (user_id, delta, balance_after, entry_type, reservation_id, actor_id, note)
+					VALUES (?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), ?)`,
+		change.userId, change.delta, balance, change.entryType, change.reservationId, change.actorId, change.note)
+	if err != nil {
+		return fmt.Errorf("error adding minute ledger entry: %v", err)
+	}
+	return nil
+}
+
+// given a change, apply it and add it to the ledger in its own transaction
+func changeMinutes(change minuteChange) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	if err := recordMinuteChange(tx, change); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// given a userId, start their ledger with the balance they have now. Users that already have entries are left alone.
+func openMinuteLedger(db dbExecutor, userId int) error {
+	_, err := db.Exec(`INSERT INTO minute_ledger (user_id, delta, balance_after, entry_type, note)
+					SELECT id, weekly_minutes, weekly_minutes, ?, 'starting balance' FROM users
+					WHERE id = ? AND NOT EXISTS (SELECT 1 FROM minute_ledger WHERE user_id = users.id)`, LedgerOpening, userId)
+	if err != nil {
+		return fmt.Errorf("error starting minute ledger of user %d: %v", userId, err)
+	}
+	return nil
+}
+
+// given a userId, return their weekly minutes and every ledger entry behind them, newest first
+func GetMinuteStatement(userId int) (*models.MinuteStatement, error) {
+	statement := models.MinuteStatement{User_Id: userId, Entries: []models.MinuteLedgerEntry{}}
+	if err := database.DB.QueryRow("SELECT weekly_minutes FROM users WHERE id = ?", userId).Scan(&statement.Weekly_Minutes); err != nil {
+		return nil, fmt.Errorf("error getting user from db: %v", err)
+	}
+
+	querySQL := `SELECT id, user_id, delta, balance_after, entry_type, COALESCE(reservation_id, 0), COALESCE(actor_id, 0), note, created_at
+				FROM minute_ledger WHERE user_id = ? ORDER BY id DESC`
+	rows, err := database.DB.Query(querySQL, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting minute ledger: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.MinuteLedgerEntry
+		if err := rows.Scan(&e.Id, &e.User_Id, &e.Delta, &e.Balance_After, &e.Entry_Type, &e.Reservation_Id, &e.Actor_Id, &e.Note, &e.Created_At); err != nil {
+			return nil, fmt.Errorf("error scanning minute ledger entry: %v", err)
+		}
+		statement.Ledger_Balance += e.Delta
+		statement.Entries = append(statement.Entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return &statement, nil
+}
+
+// returns every user whose weekly_minutes don't match the sum of their ledger entries
+func GetMinuteDiscrepancies() ([]models.MinuteDiscrepancy, error) {
+	querySQL := `SELECT u.id, u.username, u.weekly_minutes, COALESCE(SUM(l.delta), 0) AS ledger_balance
+				FROM users u
+				LEFT JOIN minute_ledger l ON l.user_id = u.id
+				GROUP BY u.id
+				HAVING u.weekly_minutes != ledger_balance
+				ORDER BY u.id ASC`
+	rows, err := database.DB.Query(querySQL)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing balances to the minute ledger: %v", err)
+	}
+	defer rows.Close()
+
+	discrepancies := []models.MinuteDiscrepancy{}
+	for rows.Next() {
+		var d models.MinuteDiscrepancy
+		if err := rows.Scan(&d.User_Id, &d.Username, &d.Weekly_Minutes, &d.Ledger_Balance); err != nil {
+			return nil, fmt.Errorf("error scanning minute discrepancy: %v", err)
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return discrepancies, nil
+}
+
+// set every user's weekly_minutes back to what their ledger adds up to, the ledger being the record of what
+// each user is owed. Returns the users that were corrected, with the balances they had before. Users whose
+// balance changed while this ran are skipped, running it again picks them up if they still don't match.
+func ReconcileMinutes() ([]models.MinuteDiscrepancy, error) {
+	discrepancies, err := GetMinuteDiscrepancies()
+	if err != nil {
+		return nil, err
+	}
+	corrected := []models.MinuteDiscrepancy{}
+	for _, d := range discrepancies {
+		// only correct the balance if nothing changed it since it was compared
+		result, err := database.DB.Exec("UPDATE users SET weekly_minutes = ? WHERE id = ? AND weekly_minutes = ?", d.Ledger_Balance, d.User_Id, d.Weekly_Minutes)
+		if err != nil {
+			return nil, fmt.Errorf("error reconciling weekly minutes of user %d: %v", d.User_Id, err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("error reconciling weekly minutes of user %d: %v", d.User_Id, err)
+		}
+		if rowsAffected == 0 {
+			log.Printf("Skipped reconciling weekly minutes of user %d, their balance changed since it was compared", d.User_Id)
+			continue
+		}
+		log.Printf("Reconciled weekly minutes of user %d from %d to the ledger balance %d", d.User_Id, d.Weekly_Minutes, d.Ledger_Balance)
+		corrected = append(corrected, d)
+	}
+	return corrected, nil
+}
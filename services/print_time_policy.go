@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gin-api/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// returned (wrapped) when a reservation is longer than the print hours allowed in a day or night window
+var ErrorPrintTimeLimit = errors.New("reservation exceeds the maximum print time")
+
+// a single day or night period in the lab's timezone. Day windows run from day_start to night_start,
+// night windows run from night_start to the next day's day_start. A window belongs to the date it starts on,
+// so Friday night (into Saturday morning) uses the weekday limits.
+type printWindow struct {
+	start     time.Time
+	end       time.Time
+	isNight   bool
+	isWeekend bool
+}
+
+// given the day_start and night_start settings ("HH:MM"), return them as minutes after midnight.
+// day_start has to come before night_start so that each calendar day has one day window and one night window.
+func parseDayNightStarts(dayStart string, nightStart string) (int, int, error) {
+	dayMinutes, err := parseClockTime(dayStart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid day_start: %v", err)
+	}
+	nightMinutes, err := parseClockTime(nightStart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid night_start: %v", err)
+	}
+	if dayMinutes >= nightMinutes {
+		return 0, 0, fmt.Errorf("day_start (%s) must be earlier than night_start (%s)", dayStart, nightStart)
+	}
+	return dayMinutes, nightMinutes, nil
+}
+
+// parse an "H:MM" or "HH:MM" clock time into minutes after midnight
+func parseClockTime(clock string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("%q is not in HH:MM format", clock)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 23 {
+		return 0, fmt.Errorf("%q has an invalid hour", clock)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("%q has an invalid minute", clock)
+	}
+	return hours*60 + minutes, nil
+}
+
+// return every day and night window that overlaps [start, end), in order, in the given location
+func printWindowsBetween(start time.Time, end time.Time, dayMinutes int, nightMinutes int, loc *time.Location) []printWindow {
+	var windows []printWindow
+
+	// begin on the previous calendar day, its night window can reach into the morning of the start date
+	local := start.In(loc)
+	date := time.Date(local.Year(), local.Month(), local.Day()-1, 0, 0, 0, 0, loc)
+	for !date.After(end) {
+		weekend := date.Weekday() == time.Saturday || date.Weekday() == time.Sunday
+		dayStart := clockTimeOn(date, dayMinutes, loc)
+		nightStart := clockTimeOn(date, nightMinutes, loc)
+		nextDate := time.Date(date.Year(), date.Month(), date.Day()+1, 0, 0, 0, 0, loc)
+		nextDayStart := clockTimeOn(nextDate, dayMinutes, loc)
+
+		for _, w := range []printWindow{
+			{start: dayStart, end: nightStart, isNight: false, isWeekend: weekend},
+			{start: nightStart, end: nextDayStart, isNight: true, isWeekend: weekend},
+		} {
+			if w.start.Before(end) && start.Before(w.end) {
+				windows = append(windows, w)
+			}
+		}
+		date = nextDate
+	}
+	return windows
+}
+
+// given a date and a clock time in minutes after midnight, return that time on that date in the given location. On
+// the days clocks change, the clock time is not the same number of minutes after midnight.
+func clockTimeOn(date time.Time, minutes int, loc *time.Location) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), minutes/60, minutes%60, 0, 0, loc)
+}
+
+// check a reservation from start to end against the weekday/weekend day/night max print hours in the time settings.
+// The time the reservation spends inside each window must not exceed that window's limit. A limit of 0 means the
+// window has no limit configured.
+func checkPrintTimeLimits(start time.Time, end time.Time) error {
+	timeSettings, err := GetTimeSettings()
+	if err != nil {
+		return fmt.Errorf("error getting time settings: %v", err)
+	}
+
+	dayMinutes, nightMinutes, err := parseDayNightStarts(timeSettings.DayStart, timeSettings.NightStart)
+	if err != nil {
+		return fmt.Errorf("error reading time settings: %v", err)
+	}
+
+	loc := util.LabLocation()
+	for _, w := range printWindowsBetween(start, end, dayMinutes, nightMinutes, loc) {
+		printTime := timeSettings.WeekdayPrintTime
+		dayType := "weekday"
+		if w.isWeekend {
+			printTime = timeSettings.WeekendPrintTime
+			dayType = "weekend"
+		}
+		limitHours := printTime.DayMaxPrintHours
+		period := "day"
+		if w.isNight {
+			limitHours = printTime.NightMaxPrintHours
+			period = "night"
+		}
+		if limitHours <= 0 {
+			continue
+		}
+
+		// portion of the reservation inside this window
+		overlapStart, overlapEnd := start, end
+		if w.start.After(overlapStart) {
+			overlapStart = w.start
+		}
+		if w.end.Before(overlapEnd) {
+			overlapEnd = w.end
+		}
+		overlap := overlapEnd.Sub(overlapStart)
+
+		if overlap > time.Duration(limitHours)*time.Hour {
+			return fmt.Errorf("%w: the %s %s limit is %d hour(s), but %d minutes were requested in the %s period starting %s",
+				ErrorPrintTimeLimit, dayType, period, limitHours, int(overlap.Minutes()), period,
+				w.start.Format("Mon Jan 2 3:04 PM"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseDayNightStarts(t *testing.T) {
+	tests := []struct {
+		name       string
+		dayStart   string
+		nightStart string
+		wantDay    int
+		wantNight  int
+		wantErr    bool
+	}{
+		{name: "defaults", dayStart: "08:00", nightStart: "20:00", wantDay: 480, wantNight: 1200},
+		{name: "single digit hour", dayStart: "7:30", nightStart: "19:45", wantDay: 450, wantNight: 1185},
+		{name: "day after night", dayStart: "20:00", nightStart: "08:00", wantErr: true},
+		{name: "day equals night", dayStart: "08:00", nightStart: "08:00", wantErr: true},
+		{name: "invalid hour", dayStart: "24:00", nightStart: "20:00", wantErr: true},
+		{name: "invalid minute", dayStart: "08:00", nightStart: "20:60", wantErr: true},
+		{name: "not a clock time", dayStart: "eight", nightStart: "20:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, night, err := parseDayNightStarts(tt.dayStart, tt.nightStart)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %d and %d", day, night)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if day != tt.wantDay || night != tt.wantNight {
+				t.Errorf("got %d and %d, want %d and %d", day, night, tt.wantDay, tt.wantNight)
+			}
+		})
+	}
+}
+
+func TestPrintWindowsBetween(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("error loading location: %v", err)
+	}
+	at := func(year int, month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name  string
+		start time.Time
+		end   time.Time
+		want  []printWindow
+	}{
+		{
+			name:  "inside a weekday day window",
+			start: at(2026, time.October, 14, 10, 0),
+			end:   at(2026, time.October, 14, 12, 0),
+			want: []printWindow{
+				{start: at(2026, time.October, 14, 8, 0), end: at(2026, time.October, 14, 20, 0)},
+			},
+		},
+		{
+			name:  "day into night",
+			start: at(2026, time.October, 14, 18, 0),
+			end:   at(2026, time.October, 14, 22, 0),
+			want: []printWindow{
+				{start: at(2026, time.October, 14, 8, 0), end: at(2026, time.October, 14, 20, 0)},
+				{start: at(2026, time.October, 14, 20, 0), end: at(2026, time.October, 15, 8, 0), isNight: true},
+			},
+		},
+		{
+			name:  "saturday morning belongs to friday night",
+			start: at(2026, time.October, 17, 2, 0),
+			end:   at(2026, time.October, 17, 4, 0),
+			want: []printWindow{
+				{start: at(2026, time.October, 16, 20, 0), end: at(2026, time.October, 17, 8, 0), isNight: true},
+			},
+		},
+		{
+			name:  "saturday day",
+			start: at(2026, time.October, 17, 10, 0),
+			end:   at(2026, time.October, 17, 11, 0),
+			want: []printWindow{
+				{start: at(2026, time.October, 17, 8, 0), end: at(2026, time.October, 17, 20, 0), isWeekend: true},
+			},
+		},
+		{
+			name:  "clocks go forward",
+			start: at(2026, time.March, 8, 10, 0),
+			end:   at(2026, time.March, 8, 11, 0),
+			want: []printWindow{
+				{start: at(2026, time.March, 8, 8, 0), end: at(2026, time.March, 8, 20, 0), isWeekend: true},
+			},
+		},
+		{
+			name:  "clocks go back",
+			start: at(2026, time.November, 1, 3, 0),
+			end:   at(2026, time.November, 1, 4, 0),
+			want: []printWindow{
+				{start: at(2026, time.October, 31, 20, 0), end: at(2026, time.November, 1, 8, 0), isNight: true, isWeekend: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := printWindowsBetween(tt.start, tt.end, 480, 1200, loc)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d windows, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if !g.start.Equal(w.start) || !g.end.Equal(w.end) || g.isNight != w.isNight || g.isWeekend != w.isWeekend {
+					t.Errorf("window %d: got %s to %s (night %v, weekend %v), want %s to %s (night %v, weekend %v)", i,
+						g.start.In(loc), g.end.In(loc), g.isNight, g.isWeekend, w.start, w.end, w.isNight, w.isWeekend)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"log"
+	"strings"
+)
+
+// statuses stored in printers.status. Available and reserved follow reservations starting and ending,
+// the rest are set by admins.
+const (
+	PrinterAvailable   = "available"
+	PrinterReserved    = "reserved"
+	PrinterMaintenance = "maintenance"
+	PrinterOffline     = "offline"
+	PrinterRetired     = "retired"
+)
+
+// define reusable printer status errors
+var (
+	ErrorPrinterUnavailable = errors.New("printer is out of service") // returned (wrapped, with the status) for maintenance, offline and retired printers
+	ErrorPrinterNotFound    = errors.New("printer not found")
+)
+
+// something that can run statements, either database.DB or a transaction
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// given a status, return whether printers in it are out of service (can't be reserved at all)
+func isOutOfService(status string) bool {
+	return status == PrinterMaintenance || status == PrinterOffline || status == PrinterRetired
+}
+
+// given a printerId, return an error if the printer is out of service
+func checkPrinterInService(printerId int) error {
+	var status string
+	err := database.DB.QueryRow("SELECT status FROM printers WHERE id = ?", printerId).Scan(&status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: no printer of ID %d exists", ErrorPrinterNotFound, printerId)
+	} else if err != nil {
+		return fmt.Errorf("error getting printer status: %v", err)
+	}
+	if isOutOfService(status) {
+		return fmt.Errorf("%w: printer %d status is %s", ErrorPrinterUnavailable, printerId, status)
+	}
+	return nil
+}
+
+// given a printer and a new status, update the printer's status and record the change in its history.
+// changedBy is the admin making the change, or 0 for changes made by reservations. Nothing is recorded if
+// the status doesn't change.
+func setPrinterStatus(db dbExecutor, printerId int, status string, reason string, changedBy int) error {
+	var current string
+	err := db.QueryRow("SELECT status FROM printers WHERE id = ?", printerId).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: no printer of ID %d exists", ErrorPrinterNotFound, printerId)
+	} else if err != nil {
+		return fmt.Errorf("error getting printer status: %v", err)
+	}
+	if current == status {
+		return nil
+	}
+
+	if _, err := db.Exec("UPDATE printers SET status = ? WHERE id = ?", status, printerId); err != nil {
+		return fmt.Errorf("error updating printer status: %v", err)
+	}
+
+	var changedByValue interface{}
+	if changedBy != 0 {
+		changedByValue = changedBy
+	}
+	_, err = db.Exec("INSERT INTO printer_status_history (printer_id, status, previous_status, reason, changed_by) VALUES (?, ?, ?, ?, ?)",
+		printerId, status, current, reason, changedByValue)
+	if err != nil {
+		return fmt.Errorf("error recording printer status change: %v", err)
+	}
+	return nil
+}
+
+// set a printer back to available once its reservation is over. Printers an admin took out of service
+// while they were reserved keep their status.
+func releasePrinterStatus(db dbExecutor, printerId int, reason string) error {
+	var current string
+	err := db.QueryRow("SELECT status FROM printers WHERE id = ?", printerId).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("error getting printer status: %v", err)
+	}
+	if current != PrinterReserved {
+		return nil
+	}
+	return setPrinterStatus(db, printerId, PrinterAvailable, reason, 0)
+}
+
+type SetPrinterStatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// given a printerId, a status and a reason, set the printer's status as the given admin. Admins can take a
+// printer out of service (maintenance, offline, retired) or put it back to available. A printer that is
+// printing for a reservation has to have the reservation ended first.
+func SetPrinterStatus(printerId int, request SetPrinterStatusRequest, adminId int) error {
+	status := strings.ToLower(strings.TrimSpace(request.Status))
+	reason := strings.TrimSpace(request.Reason)
+	if status != PrinterAvailable && !isOutOfService(status) {
+		return fmt.Errorf("status must be one of %s, %s, %s or %s", PrinterAvailable, PrinterMaintenance, PrinterOffline, PrinterRetired)
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required to change a printer's status")
+	}
+
+	var current string
+	var inUse bool
+	err := database.DB.QueryRow("SELECT status, in_use FROM printers WHERE id = ?", printerId).Scan(&current, &inUse)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: no printer of ID %d exists", ErrorPrinterNotFound, printerId)
+	} else if err != nil {
+		return fmt.Errorf("error getting printer status: %v", err)
+	}
+	if inUse {
+		return fmt.Errorf("printer %d has an active reservation, end it before changing the printer's status", printerId)
+	}
+	if current == PrinterRetired && status != PrinterRetired {
+		log.Printf("Printer %d is being brought back from retirement by user %d", printerId, adminId)
+	}
+
+	if err := setPrinterStatus(database.DB, printerId, status, reason, adminId); err != nil {
+		return err
+	}
+	log.Printf("Printer %d status set from %s to %s by user %d: %s", printerId, current, status, adminId, reason)
+
+	// a printer coming back into service can go straight to the waitlist
+	if status == PrinterAvailable && current != PrinterAvailable {
+		offerFreedPrinter(printerId)
+	}
+	return nil
+}
+
+type PrinterMaintenanceRequest struct {
+	Reason string `json:"reason"`
+}
+
+// given a printerId and a reason, put the printer into maintenance as the given admin
+func SetPrinterMaintenance(printerId int, request PrinterMaintenanceRequest, adminId int) error {
+	return SetPrinterStatus(printerId, SetPrinterStatusRequest{Status: PrinterMaintenance, Reason: request.Reason}, adminId)
+}
+
+// given a printerId and a reason, take the printer out of maintenance as the given admin
+func ClearPrinterMaintenance(printerId int, request PrinterMaintenanceRequest, adminId int) error {
+	var current string
+	err := database.DB.QueryRow("SELECT status FROM printers WHERE id = ?", printerId).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: no printer of ID %d exists", ErrorPrinterNotFound, printerId)
+	} else if err != nil {
+		return fmt.Errorf("error getting printer status: %v", err)
+	}
+	if current != PrinterMaintenance {
+		return fmt.Errorf("printer %d is not in maintenance (status is %s)", printerId, current)
+	}
+	return SetPrinterStatus(printerId, SetPrinterStatusRequest{Status: PrinterAvailable, Reason: request.Reason}, adminId)
+}
+
+// given a printerId, return its status history, newest first
+func GetPrinterStatusHistory(printerId int) ([]models.PrinterStatusChange, error) {
+	querySQL := `
+		SELECT h.id, h.printer_id, h.status, h.previous_status, h.reason, COALESCE(h.changed_by, 0), COALESCE(u.username, ''), h.changed_at
+		FROM printer_status_history h
+		LEFT JOIN users u ON h.changed_by = u.id
+		WHERE h.printer_id = ?
+		ORDER BY h.id DESC
+	`
+	rows, err := database.DB.Query(querySQL, printerId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting printer status history: %v", err)
+	}
+	defer rows.Close()
+
+	history := []models.PrinterStatusChange{}
+	for rows.Next() {
+		var h models.PrinterStatusChange
+		if err := rows.Scan(&h.Id, &h.PrinterId, &h.Status, &h.Previous_Status, &h.Reason, &h.Changed_By, &h.Changed_By_Username, &h.Changed_At); err != nil {
+			return nil, fmt.Errorf("error scanning printer status change: %v", err)
+		}
+		history = append(history, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return history, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"gin-api/database"
 	"gin-api/models"
+	"gin-api/scheduler"
 	"gin-api/util"
 	"log"
 	"strings"
@@ -14,10 +15,11 @@ import (
 	"github.com/mattn/go-sqlite3" // Import the sqlite3 driver
 )
 
-// return all printers by rack as serialized JSON
-func GetPrinters() ([]models.Printer, error) {
+// return all printers by rack as serialized JSON. Can_Reserve is filled in for the given user, and printers
+// in an EGN block are left out unless the user is in the EGN lab.
+func GetPrinters(userId int) ([]models.Printer, error) {
 	// Build query
-	query := "SELECT id, name, color, rack, rack_position, in_use, last_reserved_by, is_executive FROM printers order by rack asc, rack_position asc"
+	query := "SELECT id, name, color, rack, rack_position, in_use, status, last_reserved_by, is_executive, is_egn_printer FROM printers order by rack asc, rack_position asc"
 
 	// Execute query with appropriate parameter
 	rows, err := database.DB.Query(query)
@@ -31,7 +33,7 @@ func GetPrinters() ([]models.Printer, error) {
 		var p models.Printer
 		var lastReservedBy sql.NullString
 		// Scan rack_position
-		if err := rows.Scan(&p.Id, &p.Name, &p.Color, &p.Rack, &p.Rack_Position, &p.In_Use, &lastReservedBy, &p.Is_Executive); err != nil {
+		if err := rows.Scan(&p.Id, &p.Name, &p.Color, &p.Rack, &p.Rack_Position, &p.In_Use, &p.Status, &lastReservedBy, &p.Is_Executive, &p.Is_Egn_Printer); err != nil {
 			return nil, fmt.Errorf("scan error: %v", err)
 		}
 		if lastReservedBy.Valid {
@@ -45,9 +47,100 @@ func GetPrinters() ([]models.Printer, error) {
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows error: %v", err)
 	}
+
+	// Printers in an EGN block are hidden from regular users until the block ends
+	if printers, err = hideEgnBlockedPrinters(printers, userId); err != nil {
+		return nil, err
+	}
+	if err = attachUpcomingReservations(printers); err != nil {
+		return nil, err
+	}
+	if err = markReservablePrinters(printers, userId); err != nil {
+		return nil, err
+	}
 	return printers, nil
 }
 
+// fill in Can_Reserve of each printer for the given user. The user's quota tier (or executive access) has to allow
+// the printer's class and banned users can't reserve anything, the same as ReservePrinter. Printers that are out of
+// service can't be reserved by anyone.
+func markReservablePrinters(printers []models.Printer, userId int) error {
+	classes, err := reservablePrinterClasses(userId)
+	if err != nil {
+		return err
+	}
+
+	for i := range printers {
+		printers[i].Can_Reserve = classes[printerClass(printers[i].Is_Executive)] && !isOutOfService(printers[i].Status)
+	}
+	return nil
+}
+
+// given a userId, return the classes of printer the user could reserve right now. Empty if the user is banned or
+// doesn't exist.
+func reservablePrinterClasses(userId int) (map[string]bool, error) {
+	classes := map[string]bool{}
+	var hasExecutiveAccess bool
+	err := database.DB.QueryRow("SELECT has_executive_access FROM users WHERE id = ?", userId).Scan(&hasExecutiveAccess)
+	if err == sql.ErrNoRows {
+		return classes, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting user executive access: %v", err)
+	}
+
+	if err := checkUserBan(userId); errors.Is(err, ErrorUserBanned) {
+		return classes, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	quota, err := getUserQuota(database.DB, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, class := range []string{PrinterClassStandard, PrinterClassExecutive} {
+		classes[class] = quota.allowsPrinter(class, hasExecutiveAccess)
+	}
+	return classes, nil
+}
+
+// fill in the Upcoming_Reservations of each printer, soonest first
+func attachUpcomingReservations(printers []models.Printer) error {
+	querySQL := `
+		SELECT r.id, r.printerid, r.userId, u.username, r.time_reserved, r.time_complete
+		FROM reservations r
+		JOIN users u ON r.userId = u.id
+		WHERE r.is_scheduled = TRUE
+		ORDER BY r.time_reserved ASC
+	`
+	rows, err := database.DB.Query(querySQL)
+	if err != nil {
+		return fmt.Errorf("error getting upcoming reservations: %v", err)
+	}
+	defer rows.Close()
+
+	upcomingByPrinter := make(map[int][]models.UpcomingReservation)
+	for rows.Next() {
+		var printerId int
+		var u models.UpcomingReservation
+		if err := rows.Scan(&u.Id, &printerId, &u.UserId, &u.Username, &u.Time_Reserved, &u.Time_Complete); err != nil {
+			return fmt.Errorf("error scanning upcoming reservation: %v", err)
+		}
+		upcomingByPrinter[printerId] = append(upcomingByPrinter[printerId], u)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %v", err)
+	}
+
+	for i := range printers {
+		printers[i].Upcoming_Reservations = upcomingByPrinter[printers[i].Id]
+		if printers[i].Upcoming_Reservations == nil { // serialize as [] rather than null
+			printers[i].Upcoming_Reservations = []models.UpcomingReservation{}
+		}
+	}
+	return nil
+}
+
 // given a printer object, add a printer with those attributes. ID correlates to physical plug (1-28).
 // Rack position is automatically calculated as the next available position in the specified rack.
 func AddPrinter(request models.Printer) (bool, error) {
@@ -123,7 +216,7 @@ func AddPrinter(request models.Printer) (bool, error) {
 	}
 
 	// Insert the new printer with the calculated rack_position
-	insertSQL := `INSERT INTO printers (id, name, color, rack, rack_position, in_use, last_reserved_by, is_executive) values (?, ?, ?, ?, ?, ?, ?, ?)`
+	insertSQL := `INSERT INTO printers (id, name, color, rack, rack_position, in_use, last_reserved_by, is_executive, is_egn_printer) values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	_, err = tx.Exec(insertSQL,
 		request.Id,
 		request.Name,
@@ -132,7 +225,8 @@ func AddPrinter(request models.Printer) (bool, error) {
 		newRackPosition, // Use calculated position
 		false,           // New printers are not in use
 		nil,             // No one has reserved it yet
-		request.Is_Executive)
+		request.Is_Executive,
+		request.Is_Egn_Printer)
 	if err != nil {
 		txErr = fmt.Errorf("error inserting new printer to DB: %v", err)
 		return false, txErr
@@ -199,37 +293,99 @@ func UpdatePrinter(id int, request UpdatePrinterRequest) (bool, error) {
 }
 
 type ReservePrinterRequest struct {
-	PrinterId int `json:"printer_id"`
-	UserId    int `json:"user_id"`
-	TimeMins  int `json:"time_mins"`
+	PrinterId int        `json:"printer_id"`
+	UserId    int        `json:"user_id"` // optional, defaults to the user in the token
+	TimeMins  int        `json:"time_mins"`
+	StartTime *time.Time `json:"start_time"` // optional, leave empty to start the reservation now
+	TrimToClosure bool   `json:"trim_to_closure"` // shorten the reservation to end when the lab closes instead of refusing it
 }
 
 var (
 	manager = &models.ReservationManager{
 		Reservations: make(map[int]*models.Reservation),
 	}
+	// holds future reservations that are waiting for their start job
+	upcomingManager = &models.ReservationManager{
+		Reservations: make(map[int]*models.Reservation),
+	}
+)
+
+// define reusable reservation errors
+var (
+	ErrorPrinterBooked           = errors.New("printer is already booked") // returned (wrapped) when a window overlaps another reservation on the printer
+	ErrorExecutiveAccessRequired = errors.New("executive access is required to reserve this printer")
+	ErrorReservationConflict     = errors.New("reservation conflict") // returned (wrapped) when another request claimed the printer, a reservation slot or the minutes first
 )
 
-// given a printerId, userId, and time in minutes, reserve that printer for the user
-// and for that many minutes. Also add a timed event to complete the reservation after
-// the time in minutes has passed.
-func ReservePrinter(printerId int, userId int, timeMins int) (bool, error) {
+// how long before a reservation ends the user is warned
+const reservationWarningLead = 10 * time.Minute
+
+// start times this close to now are treated as "start now" so the kiosk clock being slightly off doesn't matter
+const scheduleTolerance = time.Minute
+
+// given a printerId, userId, time in minutes, and an optional start time, reserve that printer for the user
+// and for that many minutes. Reservations without a start time (or starting within a minute) begin immediately,
+// otherwise the reservation is booked for the future and the printer is turned on when the window starts.
+// Reservations are rejected if their window overlaps any other active or upcoming reservation on the printer.
+func ReservePrinter(request ReservePrinterRequest, requesterId int, canManage bool) (bool, error) {
+	// reservations are for the user in the token, staff who can manage reservations can book for someone else
+	if request.UserId == 0 {
+		request.UserId = requesterId
+	} else if request.UserId != requesterId && !canManage {
+		return false, ErrorNotReservationOwner
+	}
+
+	printerId, userId, timeMins := request.PrinterId, request.UserId, request.TimeMins
+	if timeMins <= 0 {
+		return false, fmt.Errorf("reservation length must be a positive number of minutes")
+	}
+
+	now := time.Now()
+	time_reserved := now
+	scheduled := false
+	if request.StartTime != nil {
+		if request.StartTime.Before(now.Add(-scheduleTolerance)) {
+			return false, fmt.Errorf("reservation start time %s is in the past", request.StartTime.Format(time.RFC3339))
+		}
+		if request.StartTime.After(now.Add(scheduleTolerance)) {
+			time_reserved = *request.StartTime
+			scheduled = true
+		}
+	}
+	time_complete := time_reserved.Add(time.Duration(timeMins) * time.Minute)
+
+	// Reservations can't run into a closure or past closing time. If asked to, end the reservation when the lab closes instead.
+	closed, err := firstClosureBetween(time_reserved, time_complete)
+	if err != nil {
+		return false, err
+	}
+	if closed != nil {
+		trimmedMins := int(closed.start.Sub(time_reserved).Minutes())
+		if !request.TrimToClosure || trimmedMins <= 0 {
+			return false, closedError(closed)
+		}
+		log.Printf("Trimmed reservation on printer %d from %d to %d minutes to end at closing (%s)", printerId, timeMins, trimmedMins, closed.reason)
+		timeMins = trimmedMins
+		time_complete = time_reserved.Add(time.Duration(timeMins) * time.Minute)
+	}
+
 	var user models.UserData
-	if err := database.DB.QueryRow("SELECT username FROM users WHERE id = ?", userId).Scan(
-		&user.Username); err != nil {
+	if err := database.DB.QueryRow("SELECT username, has_executive_access FROM users WHERE id = ?", userId).Scan(
+		&user.Username, &user.Has_Executive_Access); err != nil {
 		return false, fmt.Errorf("failed to get username: %v", err)
 	}
 
 	var printer models.Printer
 	var lastReservedBy sql.NullString
 	// Include rack_position in the select query
-	if err := database.DB.QueryRow("SELECT id, name, color, rack, rack_position, in_use, last_reserved_by, is_executive FROM printers WHERE id = ?", printerId).Scan(
+	if err := database.DB.QueryRow("SELECT id, name, color, rack, rack_position, in_use, status, last_reserved_by, is_executive FROM printers WHERE id = ?", printerId).Scan(
 		&printer.Id,
 		&printer.Name,
 		&printer.Color,
 		&printer.Rack,
 		&printer.Rack_Position, // Scan rack_position
 		&printer.In_Use,
+		&printer.Status,
 		&lastReservedBy,
 		&printer.Is_Executive); err != nil {
 		// Check if it's specifically a "no rows" error
@@ -245,18 +401,68 @@ func ReservePrinter(printerId int, userId int, timeMins int) (bool, error) {
 		printer.Last_Reserved_By = ""
 	}
 
-	if printer.In_Use {
-		return false, fmt.Errorf("printer is already in use")
+	// Printers in maintenance, offline or retired can't be reserved, now or for later
+	if isOutOfService(printer.Status) {
+		return false, fmt.Errorf("%w: printer %d status is %s", ErrorPrinterUnavailable, printerId, printer.Status)
+	}
+
+	// Banned users can't reserve until their ban ends
+	if err := checkUserBan(userId); err != nil {
+		return false, err
+	}
+
+	// The user's quota tier decides which printers they can reserve, how long for and how many at once
+	quota, err := getUserQuota(database.DB, userId)
+	if err != nil {
+		return false, err
+	}
+
+	// Executive printers are limited to users with executive access (or a tier that allows them)
+	if !quota.allowsPrinter(printerClass(printer.Is_Executive), user.Has_Executive_Access) {
+		if printer.Is_Executive {
+			return false, ErrorExecutiveAccessRequired
+		}
+		return false, ErrorPrinterClassNotAllowed
+	}
+	if err := quota.checkReservationLength(timeMins); err != nil {
+		return false, err
+	}
+
+	if !scheduled && printer.In_Use {
+		return false, fmt.Errorf("%w: printer %d is already in use", ErrorReservationConflict, printerId)
+	}
+
+	// A free printer may be held for the next person on the waitlist
+	if !scheduled {
+		holder, err := getPrinterOfferHolder(printerId)
+		if err != nil {
+			return false, err
+		}
+		if holder != 0 && holder != userId {
+			return false, ErrorPrinterHeld
+		}
+	}
+
+	// Check the requested window against everything already booked on this printer
+	if err := checkPrinterAvailability(database.DB, printerId, time_reserved, time_complete, 0); err != nil {
+		return false, err
+	}
+
+	// Check the requested window against the day/night max print hours
+	if err := checkPrintTimeLimits(time_reserved, time_complete); err != nil {
+		return false, err
 	}
 
-	// Check if user already has all of his active reservations
+	// Check if user already has all of his active reservations. Upcoming reservations count towards the
+	// limit too, otherwise users could hold every printer in the lab for later in the day.
 	var activeReservationCount int
-	if err := database.DB.QueryRow("SELECT COUNT(*) FROM reservations WHERE userid = ? AND is_active = TRUE", userId).Scan(&activeReservationCount); err != nil {
+	// EGN block bookings and series occurrences made under the user don't count.
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM reservations WHERE userid = ? AND (is_active = TRUE OR is_scheduled = TRUE) AND is_egn_reservation = FALSE AND series_id IS NULL", userId).Scan(&activeReservationCount); err != nil {
 		return false, fmt.Errorf("failed to check active reservations: %v", err)
 	}
 
 	// Check if the active reservation count is larger than the limit and return false if it is
-	limit := util.Settings.PrinterSettings.MaxActiveReservations // Set limit to the amount of active reservations that the administrator passes
+	limit := quota.maxActiveReservations // Set limit to the amount of active reservations the user's tier (or the administrator's default) allows
 	if activeReservationCount >= limit {
 		return false, fmt.Errorf("maximum of active reservations per user allowed is %d", limit)
 	}
@@ -283,44 +489,69 @@ func ReservePrinter(printerId int, userId int, timeMins int) (bool, error) {
 		}
 	}()
 
-	// Set printer as 'in use' in the database (within transaction)
-	result, err := tx.Exec(
-		"UPDATE printers SET in_use = TRUE, last_reserved_by = ? WHERE id = ?",
-		user.Username,
-		printerId,
-	)
-	if err != nil {
-		txErr = err
-		return false, fmt.Errorf("failed to update printer: %v", err)
-	}
+	// The checks above only fail fast with a friendly error. Another kiosk may have claimed the printer, a
+	// reservation slot or the user's minutes since, so every claim below is conditional and the loser of
+	// a race gets ErrorReservationConflict.
+
+	// Claim the printer (within transaction) if it is still free. Upcoming reservations leave the
+	// printer alone until their window starts.
+	if !scheduled {
+		result, err := tx.Exec(
+			"UPDATE printers SET in_use = TRUE, last_reserved_by = ? WHERE id = ? AND in_use = FALSE AND status = ?",
+			user.Username,
+			printerId,
+			PrinterAvailable,
+		)
+		if err != nil {
+			txErr = err
+			return false, fmt.Errorf("failed to update printer: %v", err)
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		txErr = err
-		return false, fmt.Errorf("failed to get affected rows: %v", err)
-	}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			txErr = err
+			return false, fmt.Errorf("failed to get affected rows: %v", err)
+		}
 
-	if rowsAffected == 0 {
-		// This case should theoretically be caught by the initial printer check, but good to have defense in depth
-		txErr = fmt.Errorf("no printer found with id: %d during update", printerId)
-		return false, txErr
+		if rowsAffected == 0 {
+			txErr = fmt.Errorf("%w: printer %d was claimed by another reservation", ErrorReservationConflict, printerId)
+			return false, txErr
+		}
+
+		if txErr = setPrinterStatus(tx, printerId, PrinterReserved, "reserved by "+user.Username, 0); txErr != nil {
+			return false, txErr
+		}
 	}
 
-	time_reserved := time.Now()
-	time_complete := time.Now().Add(time.Duration(timeMins) * time.Minute)
+	// Check the window again now that no other claim can run alongside this one. That only holds because main.go
+	// opens SQLite with _txlock=immediate, so Begin takes the write lock. With deferred transactions two claims
+	// could both pass this check before either writes, and the printer would be double-booked.
+	if txErr = checkPrinterAvailability(tx, printerId, time_reserved, time_complete, 0); txErr != nil {
+		return false, fmt.Errorf("%w: %w", ErrorReservationConflict, txErr)
+	}
 
-	// Create reservation and add it to reservations table as an entry (within transaction)
-	result, err = tx.Exec(
-		"INSERT INTO reservations (printerid, userid, time_reserved, time_complete, is_active) values (?, ?, ?, ?, ?)",
+	// Create reservation and add it to reservations table as an entry (within transaction), as long as the
+	// user is still under the active reservation limit
+	result, err := tx.Exec(
+		`INSERT INTO reservations (printerid, userid, time_reserved, time_complete, is_active, is_scheduled)
+		SELECT ?, ?, ?, ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM reservations WHERE userid = ? AND (is_active = TRUE OR is_scheduled = TRUE) AND is_egn_reservation = FALSE AND series_id IS NULL) < ?`,
 		printerId,
 		userId,
 		time_reserved,
 		time_complete,
-		true)
+		!scheduled,
+		scheduled,
+		userId,
+		limit)
 	if err != nil {
 		txErr = err
 		return false, fmt.Errorf("failed to insert reservation: %v", err)
 	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		txErr = fmt.Errorf("%w: maximum of active reservations per user allowed is %d", ErrorReservationConflict, limit)
+		return false, txErr
+	}
 
 	reservationId, err := result.LastInsertId()
 	if err != nil {
@@ -328,12 +559,13 @@ func ReservePrinter(printerId int, userId int, timeMins int) (bool, error) {
 		return false, fmt.Errorf("failed to get reservation id: %v", err)
 	}
 
-	// Subtract reservation's duration from weeklyMinutes (within transaction)
-	newWeeklyMinutes := currentWeeklyMinutes - timeMins
-	_, err = tx.Exec("UPDATE users SET weekly_minutes = ? WHERE id = ?", newWeeklyMinutes, userId)
-	if err != nil {
-		txErr = err
-		return false, fmt.Errorf("error subtracting minutes from user: %v", err)
+	// Subtract reservation's duration from weeklyMinutes (within transaction) if the user still has them
+	txErr = recordMinuteChange(tx, minuteChange{userId: userId, delta: -timeMins, entryType: LedgerDebit,
+		reservationId: int(reservationId), actorId: userId, note: fmt.Sprintf("reserved printer %d for %d minutes", printerId, timeMins)})
+	if errors.Is(txErr, ErrorInsufficientMinutes) {
+		return false, fmt.Errorf("%w: %w: the user's weekly minutes were spent by another reservation", ErrorReservationConflict, txErr)
+	} else if txErr != nil {
+		return false, fmt.Errorf("error subtracting minutes from user: %v", txErr)
 	}
 
 	// Commit transaction
@@ -342,12 +574,33 @@ func ReservePrinter(printerId int, userId int, timeMins int) (bool, error) {
 		return false, fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
+	reservation := models.Reservation{
+		Id:            int(reservationId),
+		PrinterId:     printerId,
+		UserId:        userId,
+		Time_Reserved: time_reserved,
+		Time_Complete: time_complete,
+		Is_Active:     !scheduled,
+		Is_Scheduled:  scheduled,
+	}
+
+	// Future reservations only need a job to start them, the printer stays off until then
+	if scheduled {
+		if err := trackUpcomingReservation(reservation); err != nil {
+			if _, undoErr := cancelScheduledReservation(reservation.Id, userId, time_reserved, time_complete, 0); undoErr != nil {
+				log.Printf("CRITICAL: failed to undo reservation after scheduling error: %v. Manual intervention may be required.", undoErr)
+			}
+			return false, fmt.Errorf("error scheduling reservation start: %v. Reservation has been rolled back", err)
+		}
+		return true, nil
+	}
+
 	// Only turn on printer after successful transaction
 	_, err = util.TurnOnPrinter(printerId)
 	if err != nil {
 		// Transaction was successful but printer failed to turn on
 		// We should try to undo our changes
-		undoErr := undoReservation(printerId, int(reservationId), userId, currentWeeklyMinutes)
+		undoErr := undoReservation(printerId, int(reservationId), userId, timeMins)
 		if undoErr != nil {
 			log.Printf("CRITICAL: failed to undo reservation after printer turn on error: %v. Manual intervention may be required.", undoErr)
 		} else {
@@ -356,30 +609,88 @@ func ReservePrinter(printerId int, userId int, timeMins int) (bool, error) {
 		return false, fmt.Errorf("error turning on printer: %v. Reservation has been rolled back", err)
 	}
 
-	// Set up timer to complete/end the reservation
-	timer := time.NewTimer(time.Duration(timeMins) * time.Minute)
-	manager.Mutex.Lock()
-	manager.Reservations[int(reservationId)] = &models.Reservation{
-		Id:                 int(reservationId),
-		PrinterId:          printerId,
-		UserId:             userId,
-		Time_Reserved:      time_reserved,
-		Time_Complete:      time_complete,
-		Is_Active:          true,
-		Timer:              timer,
+	// Schedule the job to complete/end the reservation
+	if err := trackActiveReservation(reservation); err != nil {
+		util.TurnOffPrinter(printerId)
+		if undoErr := undoReservation(printerId, int(reservationId), userId, timeMins); undoErr != nil {
+			log.Printf("CRITICAL: failed to undo reservation after scheduling error: %v. Manual intervention may be required.", undoErr)
+		}
+		return false, fmt.Errorf("error scheduling reservation completion: %v. Reservation has been rolled back", err)
 	}
-	manager.Mutex.Unlock()
+	scheduleCheckInDeadline(reservation.Id, time_reserved)
 
-	go func() {
-		<-timer.C
-		CompleteReservation(printerId, int(reservationId))
-	}()
+	// If the printer was being held for this user, they have now claimed it
+	claimWaitlistOffer(userId, printerId)
 
 	return true, nil
 }
 
-// Helper function to undo a reservation if printer fails to turn on
-func undoReservation(printerId, reservationId, userId, originalWeeklyMinutes int) error {
+// add an active reservation to the manager and schedule the jobs that warn the user near the end and
+// complete/end the reservation at its time_complete
+func trackActiveReservation(reservation models.Reservation) error {
+	jobId, err := scheduler.Schedule(scheduler.JobCompleteReservation, reservation.Id, reservation.Time_Complete)
+	if err != nil {
+		return err
+	}
+	reservation.JobId = jobId
+
+	warnAt := reservation.Time_Complete.Add(-reservationWarningLead)
+	if warnAt.After(time.Now()) {
+		if _, err := scheduler.Schedule(scheduler.JobWarnUser, reservation.Id, warnAt); err != nil {
+			log.Printf("failed to schedule end warning for reservation %d: %v", reservation.Id, err)
+		}
+	}
+
+	manager.Mutex.Lock()
+	manager.Reservations[reservation.Id] = &reservation
+	manager.Mutex.Unlock()
+	return nil
+}
+
+// add an upcoming reservation to the upcoming manager and schedule the job that starts it at its time_reserved
+func trackUpcomingReservation(reservation models.Reservation) error {
+	jobId, err := scheduler.Schedule(scheduler.JobStartReservation, reservation.Id, reservation.Time_Reserved)
+	if err != nil {
+		return err
+	}
+	reservation.JobId = jobId
+
+	upcomingManager.Mutex.Lock()
+	upcomingManager.Reservations[reservation.Id] = &reservation
+	upcomingManager.Mutex.Unlock()
+	return nil
+}
+
+// given a printer and a time window, return an error if any active or upcoming reservation on that printer
+// overlaps the window. ignoreReservationId is skipped so a reservation can be checked against everything but itself.
+// Pass a transaction as db to check against the bookings as that transaction sees them.
+func checkPrinterAvailability(db dbExecutor, printerId int, start time.Time, end time.Time, ignoreReservationId int) error {
+	querySQL := `SELECT id, time_reserved, time_complete FROM reservations
+				WHERE printerid = ? AND id != ? AND (is_active = TRUE OR is_scheduled = TRUE)`
+	rows, err := db.Query(querySQL, printerId, ignoreReservationId)
+	if err != nil {
+		return fmt.Errorf("failed to check printer bookings: %v", err)
+	}
+	defer rows.Close()
+
+	//compared here rather than in SQL since stored timestamps may carry different UTC offsets
+	for rows.Next() {
+		var id int
+		var bookedStart, bookedEnd time.Time
+		if err := rows.Scan(&id, &bookedStart, &bookedEnd); err != nil {
+			return fmt.Errorf("failed to scan printer booking: %v", err)
+		}
+		if start.Before(bookedEnd) && bookedStart.Before(end) {
+			return fmt.Errorf("%w: printer %d is already booked from %s to %s", ErrorPrinterBooked, printerId,
+				bookedStart.Format("Jan 2 3:04 PM"), bookedEnd.Format("Jan 2 3:04 PM"))
+		}
+	}
+	return rows.Err()
+}
+
+// Helper function to undo a reservation if printer fails to turn on. The reservation's minutes are given
+// back to the user.
+func undoReservation(printerId, reservationId, userId, reservedMinutes int) error {
 	tx, err := database.DB.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin undo transaction: %v", err)
@@ -399,6 +710,10 @@ func undoReservation(printerId, reservationId, userId, originalWeeklyMinutes int
 		txErr = fmt.Errorf("failed to undo printer status: %v", err)
 		return txErr
 	}
+	if err = releasePrinterStatus(tx, printerId, fmt.Sprintf("reservation %d was rolled back", reservationId)); err != nil {
+		txErr = err
+		return txErr
+	}
 
 	// Set reservation to inactive
 	_, err = tx.Exec("UPDATE reservations SET is_active = FALSE WHERE id = ?", reservationId)
@@ -408,7 +723,8 @@ func undoReservation(printerId, reservationId, userId, originalWeeklyMinutes int
 	}
 
 	// Restore user's weekly minutes
-	_, err = tx.Exec("UPDATE users SET weekly_minutes = ? WHERE id = ?", originalWeeklyMinutes, userId)
+	err = recordMinuteChange(tx, minuteChange{userId: userId, delta: reservedMinutes, entryType: LedgerRefund,
+		reservationId: reservationId, note: "reservation was rolled back"})
 	if err != nil {
 		txErr = fmt.Errorf("failed to restore user minutes: %v", err)
 		return txErr
@@ -417,45 +733,89 @@ func undoReservation(printerId, reservationId, userId, originalWeeklyMinutes int
 	return nil // txErr is nil, commit will happen in defer
 }
 
-// turn off the printer, set the relevant printer as not in use, set the reservation to no longer be active
+// set the reservation to no longer be active, turn off the printer and set it as not in use. Does nothing if the
+// reservation already ended, so a completion that runs late can't end whatever started on the printer since.
 func CompleteReservation(printerId, reservationId int) {
 
-	//Turn off the printer
-	_, err := util.TurnOffPrinter(printerId)
-	if err != nil {
-		log.Printf("failed to turn off printer %d: %v", printerId, err)
-		// Decide if we should proceed or retry later? For now, log and continue.
-	}
-
-	//Set as not in_use
-	_, err = database.DB.Exec(
-		"UPDATE printers SET in_use = FALSE WHERE id = ?",
-		printerId,
-	)
-	if err != nil {
-		log.Printf("failed to update printer %d status to not in use: %v", printerId, err)
-	}
-	//Set the reservation as inactive
-	_, err = database.DB.Exec(
-		"UPDATE reservations SET is_active = FALSE WHERE id = ?",
+	//Set the reservation as inactive. Only the first of the completion job, a cancel or a force-end gets to end it
+	result, err := database.DB.Exec(
+		"UPDATE reservations SET is_active = FALSE WHERE id = ? AND is_active = TRUE",
 		reservationId,
 	)
 	if err != nil {
 		log.Printf("failed to update reservation %d status to inactive: %v", reservationId, err)
+		return
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		log.Printf("Reservation %d already ended, leaving printer %d alone.", reservationId, printerId)
+		return
+	}
+	//Reservations that weren't cancelled or force-ended ran to completion
+	recordReservationEnd(reservationId, OutcomeSuccess, EndReasonCompleted, 0)
+
+	releaseReservationPrinter(printerId, reservationId)
+}
+
+// given a reservation that was just set inactive and its printer, cancel the reservation's remaining jobs and free
+// the printer: turn it off, set it as not in use and offer it to the waitlist. The printer is left alone if another
+// reservation has started on it since.
+func releaseReservationPrinter(printerId, reservationId int) {
+
+	// Cancel the completion and warning jobs in case the reservation ended early
+	if err := scheduler.Cancel(scheduler.JobCompleteReservation, reservationId); err != nil {
+		log.Printf("failed to cancel completion job of reservation %d: %v", reservationId, err)
+	}
+	if err := scheduler.Cancel(scheduler.JobWarnUser, reservationId); err != nil {
+		log.Printf("failed to cancel warning job of reservation %d: %v", reservationId, err)
+	}
+	if err := scheduler.Cancel(scheduler.JobCheckInDeadline, reservationId); err != nil {
+		log.Printf("failed to cancel check-in deadline of reservation %d: %v", reservationId, err)
 	}
 
 	// Remove from the active manager map
 	manager.Mutex.Lock()
 	// Check if the reservation still exists in the map before deleting
-	if res, ok := manager.Reservations[reservationId]; ok {
-		// Optionally stop the timer if it hasn't fired yet (though it should have)
-		res.Timer.Stop()
+	if _, ok := manager.Reservations[reservationId]; ok {
 		delete(manager.Reservations, reservationId)
 		log.Printf("Completed and removed reservation %d from active manager.", reservationId)
 	} else {
 		log.Printf("Reservation %d not found in active manager upon completion.", reservationId)
 	}
 	manager.Mutex.Unlock()
+
+	//Another reservation holds the printer, it is turned off when that one ends
+	var held bool
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM reservations WHERE printerid = ? AND is_active = TRUE)", printerId).Scan(&held)
+	if err != nil {
+		log.Printf("failed to check printer %d for other reservations: %v", printerId, err)
+		return
+	}
+	if held {
+		log.Printf("Printer %d is held by another reservation, leaving it on.", printerId)
+		return
+	}
+
+	//Turn off the printer. It is still in use until this is done, so no reservation can start on it in the meantime
+	_, err = util.TurnOffPrinter(printerId)
+	if err != nil {
+		log.Printf("failed to turn off printer %d: %v", printerId, err)
+		// Decide if we should proceed or retry later? For now, log and continue.
+	}
+
+	//Set as not in_use
+	_, err = database.DB.Exec(
+		"UPDATE printers SET in_use = FALSE WHERE id = ? AND NOT EXISTS (SELECT 1 FROM reservations WHERE printerid = ? AND is_active = TRUE)",
+		printerId, printerId,
+	)
+	if err != nil {
+		log.Printf("failed to update printer %d status to not in use: %v", printerId, err)
+	}
+	if err := releasePrinterStatus(database.DB, printerId, fmt.Sprintf("reservation %d ended", reservationId)); err != nil {
+		log.Printf("failed to set printer %d back to available: %v", printerId, err)
+	}
+
+	// Hand the printer to the next person on the waitlist
+	offerFreedPrinter(printerId)
 }
 
 // Given a printerId, toggle its is_executive bool in the printers table
@@ -484,10 +844,37 @@ func SetPrinterExecutive(id int) error {
 	return nil
 }
 
+// Given a printerId, toggle its is_egn_printer bool in the printers table
+func SetPrinterEgn(id int) error {
+
+	var currentEgn bool
+
+	querySQL := `SELECT is_egn_printer FROM printers WHERE id = ?`
+	err := database.DB.QueryRow(querySQL, id).Scan(&currentEgn)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("printer with id %d not found", id)
+		}
+		return fmt.Errorf("error getting printer EGN status from db: %v", err)
+	}
+
+	newEgn := !currentEgn
+
+	updateSQL := `UPDATE printers SET is_egn_printer = ? WHERE id = ?`
+	_, err = database.DB.Exec(updateSQL, newEgn, id)
+	if err != nil {
+		return fmt.Errorf("error updating printer EGN status: %v", err)
+	}
+
+	log.Printf("Toggled is_egn_printer for printer %d to %v", id, newEgn)
+	return nil
+}
+
 // GetPrintersByRackId returns all printers belonging to a specific rack, ordered by position.
-func GetPrintersByRackId(rackId int) ([]models.Printer, error) {
+// Can_Reserve is filled in for the given user, and printers in an EGN block are left out unless the user is in the EGN lab.
+func GetPrintersByRackId(rackId int, userId int) ([]models.Printer, error) {
 	// Query printers for the given rackId, ordered by rack_position
-	query := "SELECT id, name, color, rack, rack_position, in_use, last_reserved_by, is_executive FROM printers WHERE rack = ? ORDER BY rack_position ASC"
+	query := "SELECT id, name, color, rack, rack_position, in_use, status, last_reserved_by, is_executive, is_egn_printer FROM printers WHERE rack = ? ORDER BY rack_position ASC"
 
 	rows, err := database.DB.Query(query, rackId)
 	if err != nil {
@@ -501,7 +888,7 @@ func GetPrintersByRackId(rackId int) ([]models.Printer, error) {
 		var p models.Printer
 		var lastReservedBy sql.NullString
 		// Scan all fields including rack_position
-		if err := rows.Scan(&p.Id, &p.Name, &p.Color, &p.Rack, &p.Rack_Position, &p.In_Use, &lastReservedBy, &p.Is_Executive); err != nil {
+		if err := rows.Scan(&p.Id, &p.Name, &p.Color, &p.Rack, &p.Rack_Position, &p.In_Use, &p.Status, &lastReservedBy, &p.Is_Executive, &p.Is_Egn_Printer); err != nil {
 			// Return nil for the slice in case of a scan error, along with the error itself
 			return nil, fmt.Errorf("scan error for rack %d: %v", rackId, err)
 		}
@@ -519,6 +906,17 @@ func GetPrintersByRackId(rackId int) ([]models.Printer, error) {
 		return nil, fmt.Errorf("rows error for rack %d: %v", rackId, err)
 	}
 
+	// Printers in an EGN block are hidden from regular users until the block ends
+	if printers, err = hideEgnBlockedPrinters(printers, userId); err != nil {
+		return nil, err
+	}
+	if err = attachUpcomingReservations(printers); err != nil {
+		return nil, err
+	}
+	if err = markReservablePrinters(printers, userId); err != nil {
+		return nil, err
+	}
+
 	// Return the (potentially empty) slice and a nil error
 	return printers, nil
 }
@@ -569,6 +967,13 @@ func DeletePrinter(id int) (bool, error) {
 		}
 	}()
 
+	// Delete the printer's status history (within transaction)
+	_, err = tx.Exec("DELETE FROM printer_status_history WHERE printer_id = ?", id)
+	if err != nil {
+		txErr = fmt.Errorf("error deleting status history for printer %d: %v", id, err)
+		return false, txErr
+	}
+
 	// 4. Delete INACTIVE reservations associated with the printer (within transaction)
 	// Since we already checked for active ones, all remaining reservations for this printer ID must be inactive.
 	_, err = tx.Exec("DELETE FROM reservations WHERE printerid = ?", id)
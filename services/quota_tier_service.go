@@ -0,0 +1,251 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"strings"
+)
+
+// printer classes a quota tier can allow
+const (
+	PrinterClassStandard  = "standard"
+	PrinterClassExecutive = "executive"
+)
+
+// define reusable quota tier errors
+var (
+	ErrorTierNotFound           = errors.New("quota tier not found")
+	ErrorTierInUse              = errors.New("quota tier is assigned to users")
+	ErrorPrinterClassNotAllowed = errors.New("the user's quota tier doesn't allow this class of printer")
+	ErrorReservationTooLong     = errors.New("reservation is longer than the user's quota tier allows") // returned (wrapped) with the limit
+)
+
+// the limits that apply to a user, from their quota tier or the default settings if they don't have one
+type userQuota struct {
+	tierId                int // 0 for the default quota
+	weeklyMinutes         int
+	maxActiveReservations int
+	maxReservationMinutes int // 0 for no limit
+	printerClasses        []string
+}
+
+// given a userId, return the limits that apply to them. Users without a tier get the defaults from the settings.
+func getUserQuota(db dbExecutor, userId int) (*userQuota, error) {
+	var tierId sql.NullInt64
+	if err := db.QueryRow("SELECT quota_tier_id FROM users WHERE id = ?", userId).Scan(&tierId); err != nil {
+		return nil, fmt.Errorf("error getting quota tier of user %d: %v", userId, err)
+	}
+	if !tierId.Valid {
+		timeSettings, err := GetTimeSettings()
+		if err != nil {
+			return nil, fmt.Errorf("error getting time settings: %v", err)
+		}
+		printerSettings, err := GetPrinterSettings()
+		if err != nil {
+			return nil, fmt.Errorf("error getting printer settings: %v", err)
+		}
+		return &userQuota{
+			weeklyMinutes:         timeSettings.DefaultUserWeeklyHours * 60,
+			maxActiveReservations: printerSettings.MaxActiveReservations,
+			printerClasses:        []string{PrinterClassStandard},
+		}, nil
+	}
+
+	tier, err := getQuotaTier(db, int(tierId.Int64))
+	if err != nil {
+		return nil, err
+	}
+	return &userQuota{
+		tierId:                tier.Id,
+		weeklyMinutes:         tier.Weekly_Hours * 60,
+		maxActiveReservations: tier.Max_Active_Reservations,
+		maxReservationMinutes: tier.Max_Reservation_Minutes,
+		printerClasses:        tier.Printer_Classes,
+	}, nil
+}
+
+// given a printer's class, return whether the quota allows it. Executive printers are also allowed for users given
+// executive access individually.
+func (q *userQuota) allowsPrinter(class string, hasExecutiveAccess bool) bool {
+	if class == PrinterClassExecutive && hasExecutiveAccess {
+		return true
+	}
+	for _, allowed := range q.printerClasses {
+		if allowed == class {
+			return true
+		}
+	}
+	return false
+}
+
+// given a reservation length in minutes, return an error if it is longer than the quota allows
+func (q *userQuota) checkReservationLength(minutes int) error {
+	if q.maxReservationMinutes > 0 && minutes > q.maxReservationMinutes {
+		return fmt.Errorf("%w: %d minutes were requested, the limit is %d", ErrorReservationTooLong, minutes, q.maxReservationMinutes)
+	}
+	return nil
+}
+
+// given a printer, return its class
+func printerClass(isExecutive bool) string {
+	if isExecutive {
+		return PrinterClassExecutive
+	}
+	return PrinterClassStandard
+}
+
+// returns every quota tier along with how many users are assigned to it
+func GetQuotaTiers() ([]models.QuotaTier, error) {
+	querySQL := `SELECT t.id, t.name, t.weekly_hours, t.max_active_reservations, t.max_reservation_minutes, t.printer_classes,
+					(SELECT COUNT(*) FROM users u WHERE u.quota_tier_id = t.id)
+				FROM quota_tiers t ORDER BY t.name ASC`
+	rows, err := database.DB.Query(querySQL)
+	if err != nil {
+		return nil, fmt.Errorf("error getting quota tiers: %v", err)
+	}
+	defer rows.Close()
+
+	tiers := []models.QuotaTier{}
+	for rows.Next() {
+		var t models.QuotaTier
+		var classes string
+		if err := rows.Scan(&t.Id, &t.Name, &t.Weekly_Hours, &t.Max_Active_Reservations, &t.Max_Reservation_Minutes, &classes, &t.User_Count); err != nil {
+			return nil, fmt.Errorf("error scanning quota tier: %v", err)
+		}
+		t.Printer_Classes = splitPrinterClasses(classes)
+		tiers = append(tiers, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return tiers, nil
+}
+
+// given a tierId, return that tier
+func getQuotaTier(db dbExecutor, tierId int) (*models.QuotaTier, error) {
+	var t models.QuotaTier
+	var classes string
+	err := db.QueryRow("SELECT id, name, weekly_hours, max_active_reservations, max_reservation_minutes, printer_classes FROM quota_tiers WHERE id = ?", tierId).Scan(
+		&t.Id, &t.Name, &t.Weekly_Hours, &t.Max_Active_Reservations, &t.Max_Reservation_Minutes, &classes)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrorTierNotFound, tierId)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting quota tier: %v", err)
+	}
+	t.Printer_Classes = splitPrinterClasses(classes)
+	return &t, nil
+}
+
+// given a tier from a request, trim and check its values
+func validateQuotaTier(tier *models.QuotaTier) error {
+	tier.Name = strings.TrimSpace(tier.Name)
+	if tier.Name == "" {
+		return fmt.Errorf("tier name is required")
+	}
+	if tier.Weekly_Hours < 0 {
+		return fmt.Errorf("weekly hours can't be negative")
+	}
+	if tier.Max_Active_Reservations <= 0 {
+		return fmt.Errorf("max active reservations must be a positive number")
+	}
+	if tier.Max_Reservation_Minutes < 0 {
+		return fmt.Errorf("max reservation minutes can't be negative")
+	}
+	if len(tier.Printer_Classes) == 0 {
+		return fmt.Errorf("at least one printer class is required")
+	}
+	for i, class := range tier.Printer_Classes {
+		class = strings.ToLower(strings.TrimSpace(class))
+		if class != PrinterClassStandard && class != PrinterClassExecutive {
+			return fmt.Errorf("unknown printer class %q, must be %s or %s", class, PrinterClassStandard, PrinterClassExecutive)
+		}
+		tier.Printer_Classes[i] = class
+	}
+	return nil
+}
+
+// given a tier, add it and return it with its new id
+func CreateQuotaTier(request models.QuotaTier) (*models.QuotaTier, error) {
+	if err := validateQuotaTier(&request); err != nil {
+		return nil, err
+	}
+	result, err := database.DB.Exec(`INSERT INTO quota_tiers (name, weekly_hours, max_active_reservations, max_reservation_minutes, printer_classes)
+									VALUES (?, ?, ?, ?, ?)`, request.Name, request.Weekly_Hours, request.Max_Active_Reservations,
+		request.Max_Reservation_Minutes, strings.Join(request.Printer_Classes, ","))
+	if err != nil {
+		return nil, fmt.Errorf("error adding quota tier (names must be unique): %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting quota tier id: %v", err)
+	}
+	return getQuotaTier(database.DB, int(id))
+}
+
+// given a tierId and the tier's new values, replace them. Users on the tier get the new limits right away,
+// and the new weekly hours from the next weekly reset.
+func UpdateQuotaTier(tierId int, request models.QuotaTier) (*models.QuotaTier, error) {
+	if err := validateQuotaTier(&request); err != nil {
+		return nil, err
+	}
+	result, err := database.DB.Exec(`UPDATE quota_tiers SET name = ?, weekly_hours = ?, max_active_reservations = ?, max_reservation_minutes = ?, printer_classes = ?
+									WHERE id = ?`, request.Name, request.Weekly_Hours, request.Max_Active_Reservations,
+		request.Max_Reservation_Minutes, strings.Join(request.Printer_Classes, ","), tierId)
+	if err != nil {
+		return nil, fmt.Errorf("error updating quota tier (names must be unique): %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrorTierNotFound, tierId)
+	}
+	return getQuotaTier(database.DB, tierId)
+}
+
+// given a tierId, remove that tier. Tiers still assigned to users can't be removed.
+func DeleteQuotaTier(tierId int) error {
+	result, err := database.DB.Exec("DELETE FROM quota_tiers WHERE id = ? AND NOT EXISTS (SELECT 1 FROM users WHERE quota_tier_id = ?)", tierId, tierId)
+	if err != nil {
+		return fmt.Errorf("error deleting quota tier: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		if _, err := getQuotaTier(database.DB, tierId); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: move its users to another tier first", ErrorTierInUse)
+	}
+	return nil
+}
+
+type SetUserQuotaTierRequest struct {
+	TierId int `json:"tier_id"` // 0 puts the user back on the default quota
+}
+
+// given a userId and a tier, assign the user to the tier. Their weekly minutes change at the next weekly reset.
+func SetUserQuotaTier(userId int, request SetUserQuotaTierRequest) error {
+	if request.TierId != 0 {
+		if _, err := getQuotaTier(database.DB, request.TierId); err != nil {
+			return err
+		}
+	}
+	result, err := database.DB.Exec("UPDATE users SET quota_tier_id = NULLIF(?, 0) WHERE id = ?", request.TierId, userId)
+	if err != nil {
+		return fmt.Errorf("error setting quota tier of user: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		return ErrorUserNotFound
+	}
+	return nil
+}
+
+// given the printer_classes column, return the classes in it
+func splitPrinterClasses(classes string) []string {
+	list := []string{}
+	for _, class := range strings.Split(classes, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			list = append(list, class)
+		}
+	}
+	return list
+}
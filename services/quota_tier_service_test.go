@@ -0,0 +1,105 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"gin-api/database"
+	"gin-api/util"
+	"path/filepath"
+	"testing"
+)
+
+func TestUserQuotaAllowsPrinter(t *testing.T) {
+	standardOnly := &userQuota{printerClasses: []string{PrinterClassStandard}}
+	both := &userQuota{printerClasses: []string{PrinterClassStandard, PrinterClassExecutive}}
+	none := &userQuota{}
+
+	tests := []struct {
+		name               string
+		quota              *userQuota
+		class              string
+		hasExecutiveAccess bool
+		want               bool
+	}{
+		{name: "standard printer, standard tier", quota: standardOnly, class: PrinterClassStandard, want: true},
+		{name: "executive printer, standard tier", quota: standardOnly, class: PrinterClassExecutive, want: false},
+		{name: "executive printer, standard tier with executive access", quota: standardOnly, class: PrinterClassExecutive, hasExecutiveAccess: true, want: true},
+		{name: "executive printer, executive tier", quota: both, class: PrinterClassExecutive, want: true},
+		{name: "standard printer, no classes", quota: none, class: PrinterClassStandard, want: false},
+		{name: "standard printer, no classes with executive access", quota: none, class: PrinterClassStandard, hasExecutiveAccess: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quota.allowsPrinter(tt.class, tt.hasExecutiveAccess); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserQuotaCheckReservationLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxMinutes int
+		minutes    int
+		wantErr    bool
+	}{
+		{name: "no limit", maxMinutes: 0, minutes: 10000},
+		{name: "under the limit", maxMinutes: 120, minutes: 60},
+		{name: "at the limit", maxMinutes: 120, minutes: 120},
+		{name: "over the limit", maxMinutes: 120, minutes: 121, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quota := &userQuota{maxReservationMinutes: tt.maxMinutes}
+			err := quota.checkReservationLength(tt.minutes)
+			if tt.wantErr && !errors.Is(err, ErrorReservationTooLong) {
+				t.Errorf("got %v, want ErrorReservationTooLong", err)
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestPrinterClass(t *testing.T) {
+	if got := printerClass(false); got != PrinterClassStandard {
+		t.Errorf("got %s for a standard printer", got)
+	}
+	if got := printerClass(true); got != PrinterClassExecutive {
+		t.Errorf("got %s for an executive printer", got)
+	}
+}
+
+func TestGetUserQuotaLoadsDefaults(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	previousDB, previousSettings := database.DB, util.Settings
+	database.SetDB(db)
+	t.Cleanup(func() {
+		database.SetDB(previousDB)
+		util.Settings = previousSettings
+		db.Close()
+	})
+	if err := database.EnsureSchema(); err != nil {
+		t.Fatalf("error setting up database: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE settings SET default_user_weekly_hours = 12, max_active_reservations = 3 WHERE name = "default"`); err != nil {
+		t.Fatalf("error changing settings: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (id, username) VALUES (1, 'test')"); err != nil {
+		t.Fatalf("error adding user: %v", err)
+	}
+
+	// nothing has loaded the settings yet
+	util.ToggleUpToDateAll(false)
+	quota, err := getUserQuota(database.DB, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if quota.weeklyMinutes != 12*60 || quota.maxActiveReservations != 3 {
+		t.Errorf("got %d weekly minutes and %d active reservations, want %d and 3", quota.weeklyMinutes, quota.maxActiveReservations, 12*60)
+	}
+}
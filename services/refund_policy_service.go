@@ -0,0 +1,231 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// refund types stored in refund_policies.refund_type
+const (
+	RefundNone      = "none"      // nothing is refunded
+	RefundRemaining = "remaining" // the time left in the reservation is refunded
+	RefundFull      = "full"      // the whole reservation is refunded
+	RefundFixed     = "fixed"     // a fixed number of minutes is refunded
+)
+
+// end reasons recorded on reservations that weren't force-ended by an admin
+const (
+	EndReasonCompleted   = "completed"
+	EndReasonCancelled   = "cancelled"
+	EndReasonPrintFailed = "print_failed" // also the reason code whose refund policy applies to failure reports
+	EndReasonNoShow      = "no_show"      // also the reason code whose refund policy applies to no-shows
+)
+
+// outcomes stored in reservations.outcome once a reservation ends
+const (
+	OutcomeSuccess   = "success"   // ran to its end time
+	OutcomeFailed    = "failed"    // the user reported the print failed
+	OutcomeCancelled = "cancelled" // cancelled by the user before it ended
+	OutcomeAborted   = "aborted"   // force-ended by an admin
+	OutcomeNoShow    = "no_show"   // released because the user never checked in
+)
+
+// define reusable refund policy errors
+var (
+	ErrorUnknownReasonCode  = errors.New("unknown reason code")
+	ErrorReservationNotOpen = errors.New("reservation is not active or upcoming")
+)
+
+// returns every refund policy, by reason code
+func GetRefundPolicies() ([]models.RefundPolicy, error) {
+	rows, err := database.DB.Query("SELECT reason_code, refund_type, fixed_minutes, description FROM refund_policies ORDER BY reason_code ASC")
+	if err != nil {
+		return nil, fmt.Errorf("error getting refund policies: %v", err)
+	}
+	defer rows.Close()
+
+	policies := []models.RefundPolicy{}
+	for rows.Next() {
+		var p models.RefundPolicy
+		if err := rows.Scan(&p.Reason_Code, &p.Refund_Type, &p.Fixed_Minutes, &p.Description); err != nil {
+			return nil, fmt.Errorf("error scanning refund policy: %v", err)
+		}
+		policies = append(policies, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return policies, nil
+}
+
+// given a refund policy, add it or replace the policy with the same reason code
+func SetRefundPolicy(request models.RefundPolicy) error {
+	request.Reason_Code = strings.ToLower(strings.TrimSpace(request.Reason_Code))
+	if request.Reason_Code == "" {
+		return fmt.Errorf("reason_code is required")
+	}
+	if request.Reason_Code == EndReasonCompleted || request.Reason_Code == EndReasonCancelled {
+		return fmt.Errorf("%q is reserved for reservations that weren't force-ended", request.Reason_Code)
+	}
+	switch request.Refund_Type {
+	case RefundNone, RefundRemaining, RefundFull:
+		request.Fixed_Minutes = 0
+	case RefundFixed:
+		if request.Fixed_Minutes <= 0 {
+			return fmt.Errorf("fixed_minutes must be a positive number for a fixed refund")
+		}
+	default:
+		return fmt.Errorf("refund_type must be one of %s, %s, %s or %s", RefundNone, RefundRemaining, RefundFull, RefundFixed)
+	}
+
+	upsertSQL := `INSERT INTO refund_policies (reason_code, refund_type, fixed_minutes, description) VALUES (?, ?, ?, ?)
+				ON CONFLICT(reason_code) DO UPDATE SET refund_type = excluded.refund_type, fixed_minutes = excluded.fixed_minutes, description = excluded.description`
+	_, err := database.DB.Exec(upsertSQL, request.Reason_Code, request.Refund_Type, request.Fixed_Minutes, request.Description)
+	if err != nil {
+		return fmt.Errorf("error saving refund policy: %v", err)
+	}
+	return nil
+}
+
+// given a reason code, return its refund policy
+func getRefundPolicy(reasonCode string) (*models.RefundPolicy, error) {
+	var p models.RefundPolicy
+	err := database.DB.QueryRow("SELECT reason_code, refund_type, fixed_minutes, description FROM refund_policies WHERE reason_code = ?",
+		strings.ToLower(strings.TrimSpace(reasonCode))).Scan(&p.Reason_Code, &p.Refund_Type, &p.Fixed_Minutes, &p.Description)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %q", ErrorUnknownReasonCode, reasonCode)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting refund policy: %v", err)
+	}
+	return &p, nil
+}
+
+// given a policy and a reservation's window, return how many minutes the policy refunds if the reservation
+// ends now. Partial minutes left in the reservation are rounded up in the user's favor.
+func refundMinutes(policy *models.RefundPolicy, timeReserved time.Time, timeComplete time.Time) int {
+	fullMinutes := int(math.Ceil(timeComplete.Sub(timeReserved).Minutes()))
+	remaining := timeComplete.Sub(time.Now())
+	if time.Now().Before(timeReserved) { // hasn't started yet, all of it is left
+		remaining = timeComplete.Sub(timeReserved)
+	}
+	remainingMinutes := int(math.Ceil(remaining.Minutes()))
+	if remainingMinutes < 0 {
+		remainingMinutes = 0
+	}
+
+	switch policy.Refund_Type {
+	case RefundRemaining:
+		return remainingMinutes
+	case RefundFull:
+		return fullMinutes
+	case RefundFixed:
+		if policy.Fixed_Minutes > fullMinutes {
+			return fullMinutes
+		}
+		return policy.Fixed_Minutes
+	default:
+		return 0
+	}
+}
+
+type ForceEndReservationRequest struct {
+	ReservationId int    `json:"reservation_id"`
+	ReasonCode    string `json:"reason_code"`
+}
+
+// the outcome of a force-end, returned to the admin
+type ForceEndResult struct {
+	ReservationId   int    `json:"reservation_id"`
+	ReasonCode      string `json:"reason_code"`
+	RefundedMinutes int    `json:"refunded_minutes"`
+}
+
+// given a reservation and a reason code, end the reservation now as the given admin. Works on running and
+// upcoming reservations. The refund follows the reason's refund policy (reservations that were never charged
+// aren't refunded), and the reason, refund and admin are recorded on the reservation.
+func ForceEndReservation(request ForceEndReservationRequest, adminId int) (*ForceEndResult, error) {
+	policy, err := getRefundPolicy(request.ReasonCode)
+	if err != nil {
+		return nil, err
+	}
+
+	var r models.Reservation
+	var uncharged bool
+	err = database.DB.QueryRow(`SELECT id, printerid, userId, time_reserved, time_complete, is_active, is_scheduled, is_egn_reservation OR series_id IS NOT NULL
+								FROM reservations WHERE id = ?`, request.ReservationId).Scan(
+		&r.Id, &r.PrinterId, &r.UserId, &r.Time_Reserved, &r.Time_Complete, &r.Is_Active, &r.Is_Scheduled, &uncharged)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no reservation of ID %d exists", request.ReservationId)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting reservation: %v", err)
+	}
+	if !r.Is_Active && !r.Is_Scheduled {
+		return nil, fmt.Errorf("%w: reservation %d has already ended", ErrorReservationNotOpen, r.Id)
+	}
+
+	refund := 0
+	if !uncharged {
+		refund = refundMinutes(policy, r.Time_Reserved, r.Time_Complete)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// only end the reservation if the completion or start job hasn't changed it in the meantime
+	updateSQL := `UPDATE reservations SET is_active = FALSE, is_scheduled = FALSE, outcome = ?, end_reason = ?, refunded_minutes = ?, ended_by = ?, ended_at = ?
+				WHERE id = ? AND is_active = ? AND is_scheduled = ?`
+	result, err := tx.Exec(updateSQL, OutcomeAborted, policy.Reason_Code, refund, adminId, time.Now(), r.Id, r.Is_Active, r.Is_Scheduled)
+	if err != nil {
+		txErr = err
+		return nil, fmt.Errorf("error ending reservation: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		txErr = fmt.Errorf("reservation %d changed while it was being ended, try again", r.Id)
+		return nil, txErr
+	}
+
+	if refund > 0 {
+		txErr = recordMinuteChange(tx, minuteChange{userId: r.UserId, delta: refund, entryType: LedgerRefund,
+			reservationId: r.Id, actorId: adminId, note: "force-ended for " + policy.Reason_Code})
+		if txErr != nil {
+			return nil, fmt.Errorf("error refunding weekly minutes to user: %v", txErr)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	if r.Is_Active {
+		releaseReservationPrinter(r.PrinterId, r.Id)
+	} else {
+		forgetUpcomingReservations([]int{r.Id})
+	}
+
+	log.Printf("Reservation %d force-ended by user %d (%s), refunded %d minutes to user %d", r.Id, adminId, policy.Reason_Code, refund, r.UserId)
+	return &ForceEndResult{ReservationId: r.Id, ReasonCode: policy.Reason_Code, RefundedMinutes: refund}, nil
+}
+
+// record how a reservation that wasn't force-ended came to an end. Reservations that already have an outcome keep it.
+func recordReservationEnd(reservationId int, outcome string, reason string, refundedMinutes int) {
+	_, err := database.DB.Exec("UPDATE reservations SET outcome = ?, end_reason = ?, refunded_minutes = ?, ended_at = ? WHERE id = ? AND outcome IS NULL",
+		outcome, reason, refundedMinutes, time.Now(), reservationId)
+	if err != nil {
+		log.Printf("failed to record end of reservation %d: %v", reservationId, err)
+	}
+}
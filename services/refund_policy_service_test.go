@@ -0,0 +1,41 @@
+package services
+
+import (
+	"gin-api/models"
+	"testing"
+	"time"
+)
+
+func TestRefundMinutes(t *testing.T) {
+	now := time.Now()
+	inProgress := [2]time.Time{now.Add(-30 * time.Minute), now.Add(90 * time.Minute)}                 //120 minutes, 90 left
+	notStarted := [2]time.Time{now.Add(time.Hour), now.Add(3 * time.Hour)}                            //120 minutes, all left
+	ended := [2]time.Time{now.Add(-2 * time.Hour), now.Add(-time.Hour)}                               //60 minutes, none left
+	partial := [2]time.Time{now.Add(time.Hour), now.Add(time.Hour + 90*time.Minute + 30*time.Second)} //90.5 minutes, all left
+
+	tests := []struct {
+		name   string
+		policy models.RefundPolicy
+		window [2]time.Time
+		want   int
+	}{
+		{name: "none", policy: models.RefundPolicy{Refund_Type: RefundNone}, window: inProgress, want: 0},
+		{name: "remaining, in progress", policy: models.RefundPolicy{Refund_Type: RefundRemaining}, window: inProgress, want: 90},
+		{name: "remaining, not started", policy: models.RefundPolicy{Refund_Type: RefundRemaining}, window: notStarted, want: 120},
+		{name: "remaining, already over", policy: models.RefundPolicy{Refund_Type: RefundRemaining}, window: ended, want: 0},
+		{name: "remaining, partial minute rounded up", policy: models.RefundPolicy{Refund_Type: RefundRemaining}, window: partial, want: 91},
+		{name: "full, in progress", policy: models.RefundPolicy{Refund_Type: RefundFull}, window: inProgress, want: 120},
+		{name: "full, already over", policy: models.RefundPolicy{Refund_Type: RefundFull}, window: ended, want: 60},
+		{name: "full, partial minute rounded up", policy: models.RefundPolicy{Refund_Type: RefundFull}, window: partial, want: 91},
+		{name: "fixed", policy: models.RefundPolicy{Refund_Type: RefundFixed, Fixed_Minutes: 15}, window: inProgress, want: 15},
+		{name: "fixed, capped at the reservation", policy: models.RefundPolicy{Refund_Type: RefundFixed, Fixed_Minutes: 500}, window: ended, want: 60},
+		{name: "unknown type", policy: models.RefundPolicy{Refund_Type: "bonus"}, window: inProgress, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refundMinutes(&tt.policy, tt.window[0], tt.window[1]); got != tt.want {
+				t.Errorf("got %d minutes, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,13 +2,17 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"gin-api/database"
 	"gin-api/models"
+	"gin-api/scheduler"
+	"gin-api/util"
+	"log"
 	"time"
 )
 
-//returns all active reservations including printer and user names
+//returns all active reservations including printer and user names. EGN block bookings are reported separately by GetEgnReservations.
 func GetActiveReservations() ([]models.ReservationDTO, error) {
 	query := `
 		SELECT 
@@ -17,7 +21,7 @@ func GetActiveReservations() ([]models.ReservationDTO, error) {
 		FROM reservations r
 		JOIN printers p ON r.printerId = p.id
 		JOIN users u ON r.userId = u.id
-		WHERE r.is_active = 1
+		WHERE r.is_active = 1 AND r.is_egn_reservation = 0
 		ORDER BY r.time_reserved DESC
 	`
 	rows, err := database.DB.Query(query)
@@ -45,51 +49,511 @@ func GetActiveReservations() ([]models.ReservationDTO, error) {
 }
 
 type CancelActiveReservationRequest struct {
-	PrinterId     int `json:"printer_id"`
+	PrinterId     int `json:"printer_id"` // no longer needed, the reservation's own printer is used
 	ReservationId int `json:"reservation_id"`
 }
 
-// Cancel the reservation specified by the printerId and reservationId, refund the reservation's remaining time to the user
-func CancelActiveReservation(request CancelActiveReservationRequest) (bool, error) {
-	var userId int
-	var isActive bool
-	var timeComplete time.Time
+// Cancel the reservation specified by the reservationId, refund the reservation's remaining time to the user.
+// Upcoming reservations that haven't started yet are cancelled with their full duration refunded. actorId is the
+// user cancelling it, recorded with the refund. Only the reservation's user (or staff who can manage reservations)
+// can cancel it.
+func CancelActiveReservation(request CancelActiveReservationRequest, actorId int, canManage bool) (bool, error) {
+	var userId, printerId int
+	var isActive, isScheduled, isUncharged bool
+	var timeReserved, timeComplete time.Time
 
-	//pull userId and is_active from the reservation
-	err := database.DB.QueryRow("SELECT userId, is_active, time_complete FROM reservations WHERE id = ?", request.ReservationId).Scan(&userId, &isActive, &timeComplete)
+	//pull userId, printerid, is_active and is_scheduled from the reservation
+	err := database.DB.QueryRow("SELECT userId, printerid, is_active, is_scheduled, is_egn_reservation OR series_id IS NOT NULL, time_reserved, time_complete FROM reservations WHERE id = ?", request.ReservationId).Scan(&userId, &printerId, &isActive, &isScheduled, &isUncharged, &timeReserved, &timeComplete)
 
 	if err == sql.ErrNoRows { //handle nonexistent reservation
 		return false, fmt.Errorf("error cancelling reservation, no reservation of ID %d exists", request.ReservationId)
-	} else if !isActive { //handle reservation that isn't active
-		return false, fmt.Errorf("error cancelling reservation, the reservation requested for cancellation is not active")
 	} else if err != nil { //handle all other errors from query
 		return false, fmt.Errorf("error cancelling reservation: %v", err)
+	} else if !canManage && userId != actorId {
+		return false, ErrorNotReservationOwner
+	} else if isScheduled { //reservation hasn't started yet, nothing to turn off
+		return cancelScheduledReservation(request.ReservationId, userId, timeReserved, timeComplete, actorId)
+	} else if !isActive { //handle reservation that isn't active
+		return false, fmt.Errorf("error cancelling reservation, the reservation requested for cancellation is not active")
 	}
 
 	//get time that was left in the reservation
-	timeToRefund := time.Until(timeComplete)
-	if timeToRefund < 0 { //realistically shouldn't ever happen because we already checked if !isActive, more of a precaution than anything
+	if time.Until(timeComplete) < 0 { //realistically shouldn't ever happen because we already checked if !isActive, more of a precaution than anything
 		return false, fmt.Errorf("error cancelling reservation: the requested reservation is already over")
 	}
 
-	//convert time to minutes so its compatible with weekly_minutes db column
-	minutesToRefund := int(timeToRefund.Minutes())
+	//refund the minutes left, rounded the same way as a force-end that refunds the remaining time
+	minutesToRefund := refundMinutes(&models.RefundPolicy{Refund_Type: RefundRemaining}, timeReserved, timeComplete)
+	//EGN block bookings and series occurrences were never charged, so there is nothing to refund
+	if isUncharged {
+		minutesToRefund = 0
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	//only cancel if nothing (another cancel, the completion job, a force-end) ended the reservation in the meantime
+	result, err := tx.Exec(`UPDATE reservations SET is_active = FALSE, outcome = ?, end_reason = ?, refunded_minutes = ?, ended_at = ?
+						WHERE id = ? AND is_active = TRUE`, OutcomeCancelled, EndReasonCancelled, minutesToRefund, time.Now(), request.ReservationId)
+	if err != nil {
+		txErr = err
+		return false, fmt.Errorf("error cancelling reservation: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		txErr = fmt.Errorf("reservation %d has already ended", request.ReservationId)
+		return false, fmt.Errorf("error cancelling reservation: %v", txErr)
+	}
+
+	if minutesToRefund > 0 {
+		txErr = recordMinuteChange(tx, minuteChange{userId: userId, delta: minutesToRefund, entryType: LedgerRefund,
+			reservationId: request.ReservationId, actorId: actorId, note: "cancelled with time remaining"})
+		if txErr != nil {
+			return false, fmt.Errorf("error refunding weekly minutes to user: %v", txErr)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	//now that the cancellation is refunded and recorded, turn the printer off and free it
+	releaseReservationPrinter(printerId, request.ReservationId)
+	return true, nil
+}
+
+// cancel a reservation that has not started yet. The printer was never turned on, so the whole
+// reserved duration goes back to the user and the start job is cancelled. Reservations that were never
+// charged (EGN block bookings and series occurrences) aren't refunded. actorId is whoever cancelled it, 0 for the API itself.
+func cancelScheduledReservation(reservationId int, userId int, timeReserved time.Time, timeComplete time.Time, actorId int) (bool, error) {
+	minutesToRefund := refundMinutes(&models.RefundPolicy{Refund_Type: RefundFull}, timeReserved, timeComplete)
+	uncharged, err := isUnchargedReservation(reservationId)
+	if err != nil {
+		return false, err
+	}
+	if uncharged {
+		minutesToRefund = 0
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	//only cancel if the reservation is still waiting to start, its start job may have run in the meantime
+	result, err := tx.Exec("UPDATE reservations SET is_scheduled = FALSE WHERE id = ? AND is_scheduled = TRUE", reservationId)
+	if err != nil {
+		txErr = err
+		return false, fmt.Errorf("error cancelling upcoming reservation: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		txErr = fmt.Errorf("reservation %d has already started", reservationId)
+		return false, fmt.Errorf("error cancelling reservation: %v", txErr)
+	}
+
+	if minutesToRefund > 0 {
+		txErr = recordMinuteChange(tx, minuteChange{userId: userId, delta: minutesToRefund, entryType: LedgerRefund,
+			reservationId: reservationId, actorId: actorId, note: "cancelled before it started"})
+		if txErr != nil {
+			return false, fmt.Errorf("error refunding weekly minutes to user: %v", txErr)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	recordReservationEnd(reservationId, OutcomeCancelled, EndReasonCancelled, minutesToRefund)
+
+	if err := scheduler.Cancel(scheduler.JobStartReservation, reservationId); err != nil {
+		log.Printf("failed to cancel start job of reservation %d: %v", reservationId, err)
+	}
+	upcomingManager.Mutex.Lock()
+	delete(upcomingManager.Reservations, reservationId)
+	upcomingManager.Mutex.Unlock()
+
+	log.Printf("Cancelled upcoming reservation %d and refunded %d minutes to user %d", reservationId, minutesToRefund, userId)
+	return true, nil
+}
+
+// how long a reservation waits to start when the reservation before it on the printer hasn't been completed yet
+const printerBusyRetryDelay = 30 * time.Second
+
+// runs when an upcoming reservation's window starts (from its start job). Marks the reservation active and the
+// printer in use, turns the printer on, and schedules the job that completes the reservation at its time_complete.
+// Does nothing if the reservation was cancelled or already started, so it is safe to run more than once. If the
+// printer is still in use the start is tried again shortly, until the reservation's window has passed.
+func StartScheduledReservation(reservationId int) error {
+	var r models.Reservation
+	var username string
+	querySQL := `SELECT r.printerid, r.userId, u.username, r.time_reserved, r.time_complete
+				FROM reservations r
+				JOIN users u ON r.userId = u.id
+				WHERE r.id = ? AND r.is_scheduled = TRUE`
+	err := database.DB.QueryRow(querySQL, reservationId).Scan(&r.PrinterId, &r.UserId, &username, &r.Time_Reserved, &r.Time_Complete)
+	if err == sql.ErrNoRows { //cancelled or started already
+		upcomingManager.Mutex.Lock()
+		delete(upcomingManager.Reservations, reservationId)
+		upcomingManager.Mutex.Unlock()
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get upcoming reservation %d: %v", reservationId, err)
+	}
+	r.Id = reservationId
+
+	//the printer was taken out of service after this was booked, give the user their time back
+	if err := checkPrinterInService(r.PrinterId); err != nil {
+		if _, cancelErr := cancelScheduledReservation(r.Id, r.UserId, r.Time_Reserved, r.Time_Complete, 0); cancelErr != nil {
+			return fmt.Errorf("failed to cancel upcoming reservation %d on out of service printer: %v", r.Id, cancelErr)
+		}
+		log.Printf("Cancelled upcoming reservation %d: %v", r.Id, err)
+		return nil
+	}
+
+	//the whole window passed without the reservation starting (API was offline), give the user their time back
+	if !r.Time_Complete.After(time.Now()) {
+		if _, err := cancelScheduledReservation(r.Id, r.UserId, r.Time_Reserved, r.Time_Complete, 0); err != nil {
+			return fmt.Errorf("failed to expire missed upcoming reservation %d: %v", r.Id, err)
+		}
+		return nil
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction to start reservation %d: %v", r.Id, err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	//only start the reservation if it wasn't cancelled since it was looked up
+	result, err := tx.Exec("UPDATE reservations SET is_active = TRUE, is_scheduled = FALSE WHERE id = ? AND is_scheduled = TRUE", r.Id)
+	if err != nil {
+		txErr = err
+		return fmt.Errorf("failed to activate reservation %d: %v", r.Id, err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		tx.Rollback()
+		upcomingManager.Mutex.Lock()
+		delete(upcomingManager.Reservations, reservationId)
+		upcomingManager.Mutex.Unlock()
+		return nil
+	}
+
+	//the reservation before this one on the printer may not have been completed yet, wait for it to free the printer
+	result, err = tx.Exec("UPDATE printers SET in_use = TRUE, last_reserved_by = ? WHERE id = ? AND in_use = FALSE", username, r.PrinterId)
+	if err != nil {
+		txErr = err
+		return fmt.Errorf("failed to set printer %d in use for reservation %d: %v", r.PrinterId, r.Id, err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		tx.Rollback()
+		if _, err := scheduler.Schedule(scheduler.JobStartReservation, r.Id, time.Now().Add(printerBusyRetryDelay)); err != nil {
+			return fmt.Errorf("failed to delay start of reservation %d: %v", r.Id, err)
+		}
+		log.Printf("Printer %d is still in use, delaying start of reservation %d", r.PrinterId, r.Id)
+		return nil
+	}
+	if txErr = setPrinterStatus(tx, r.PrinterId, PrinterReserved, fmt.Sprintf("reservation %d started", r.Id), 0); txErr != nil {
+		return txErr
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit start of reservation %d: %v", r.Id, err)
+	}
+
+	upcomingManager.Mutex.Lock()
+	delete(upcomingManager.Reservations, reservationId)
+	upcomingManager.Mutex.Unlock()
+
+	r.Is_Active = true
+	if _, err := util.TurnOnPrinter(r.PrinterId); err != nil {
+		//leave the reservation running so it still completes and frees the printer, but make it visible
+		log.Printf("CRITICAL: failed to turn on printer %d for upcoming reservation %d: %v", r.PrinterId, r.Id, err)
+	}
+
+	if err := trackActiveReservation(r); err != nil {
+		//the reservation is active in the db now, so recovery will pick it up on the next start even if this fails
+		log.Printf("CRITICAL: failed to schedule completion of reservation %d: %v", r.Id, err)
+	}
+	scheduleCheckInDeadline(r.Id, r.Time_Reserved)
+	log.Printf("Started upcoming reservation %d on printer %d", r.Id, r.PrinterId)
+	return nil
+}
+
+// used by startup recovery for a reservation that was active when the API went down and hasn't ended yet.
+// Turns its printer back on, makes sure the printer is still marked in use, and adds the reservation back
+// to the manager with a job that completes it at its time_complete.
+func RestoreActiveReservation(reservation models.Reservation) error {
+	if _, err := util.TurnOnPrinter(reservation.PrinterId); err != nil {
+		return fmt.Errorf("error turning printer %d back on: %v", reservation.PrinterId, err)
+	}
+
+	_, err := database.DB.Exec("UPDATE printers SET in_use = TRUE WHERE id = ?", reservation.PrinterId)
+	if err != nil {
+		return fmt.Errorf("error marking printer %d in use: %v", reservation.PrinterId, err)
+	}
+	var status string
+	if err := database.DB.QueryRow("SELECT status FROM printers WHERE id = ?", reservation.PrinterId).Scan(&status); err != nil {
+		return fmt.Errorf("error getting status of printer %d: %v", reservation.PrinterId, err)
+	}
+	if status == PrinterAvailable {
+		if err := setPrinterStatus(database.DB, reservation.PrinterId, PrinterReserved, fmt.Sprintf("reservation %d restored on startup", reservation.Id), 0); err != nil {
+			return err
+		}
+	}
+
+	reservation.Is_Active = true
+	if err := trackActiveReservation(reservation); err != nil {
+		return fmt.Errorf("error scheduling completion: %v", err)
+	}
+	log.Printf("Restored active reservation %d on printer %d, completing at %s", reservation.Id, reservation.PrinterId, reservation.Time_Complete.Format(time.RFC3339))
+	return nil
+}
+
+// used by startup recovery to make sure a reservation that is still in the future has its start job
+func RestoreUpcomingReservation(reservation models.Reservation) error {
+	reservation.Is_Scheduled = true
+	return trackUpcomingReservation(reservation)
+}
+
+// returned when a user tries to act on a reservation that isn't theirs
+var ErrorNotReservationOwner = errors.New("reservation belongs to another user")
+
+// returned (wrapped) when a user doesn't have enough weekly minutes left for a request
+var ErrorInsufficientMinutes = errors.New("not enough weekly minutes")
+
+type ExtendReservationRequest struct {
+	ReservationId  int `json:"reservation_id"`
+	AdditionalMins int `json:"additional_mins"`
+}
+
+// given a reservationId and a number of minutes, push the end of an active reservation back by that many minutes.
+// The extension is charged to the reservation's user, has to fit in the day/night print limits, and can't run into
+// an upcoming reservation on the same printer. Only the reservation's user (or staff who can manage reservations) can extend it.
+func ExtendReservation(request ExtendReservationRequest, requesterId int, canManage bool) (bool, error) {
+	if request.AdditionalMins <= 0 {
+		return false, fmt.Errorf("additional minutes must be a positive number")
+	}
+
+	var r models.Reservation
+	err := database.DB.QueryRow("SELECT id, printerid, userId, time_reserved, time_complete, is_active, is_egn_reservation, COALESCE(series_id, 0) FROM reservations WHERE id = ?", request.ReservationId).Scan(
+		&r.Id, &r.PrinterId, &r.UserId, &r.Time_Reserved, &r.Time_Complete, &r.Is_Active, &r.Is_Egn_Reservation, &r.Series_Id)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("no reservation of ID %d exists", request.ReservationId)
+	} else if err != nil {
+		return false, fmt.Errorf("error getting reservation: %v", err)
+	}
+
+	if !canManage && r.UserId != requesterId {
+		return false, ErrorNotReservationOwner
+	}
+	if !r.Is_Active {
+		return false, fmt.Errorf("only active reservations can be extended")
+	}
 
-	var userWeeklyMinutes int
-	//pull user's current weekly_minutes out of the database
-	err = database.DB.QueryRow("SELECT weekly_minutes FROM users WHERE id = ?", userId).Scan(&userWeeklyMinutes)
+	newTimeComplete := r.Time_Complete.Add(time.Duration(request.AdditionalMins) * time.Minute)
+	charged := !r.Is_Egn_Reservation && r.Series_Id == 0
+
+	// the whole reservation, including the extension, has to stay within the print limits.
+	// EGN block bookings and series occurrences are exempt, like when they were booked.
+	if charged {
+		if err := checkPrintTimeLimits(r.Time_Reserved, newTimeComplete); err != nil {
+			return false, err
+		}
+		quota, err := getUserQuota(database.DB, r.UserId)
+		if err != nil {
+			return false, err
+		}
+		if err := quota.checkReservationLength(int(newTimeComplete.Sub(r.Time_Reserved).Minutes())); err != nil {
+			return false, err
+		}
+	}
+
+	// the added time can't run into a closure
+	if err := checkLabOpen(r.Time_Complete, newTimeComplete); err != nil {
+		return false, err
+	}
+
+	// the added time can't overlap the next booking on this printer
+	if err := checkPrinterAvailability(database.DB, r.PrinterId, r.Time_Complete, newTimeComplete, r.Id); err != nil {
+		return false, err
+	}
+
+	tx, err := database.DB.Begin()
 	if err != nil {
-		return false, fmt.Errorf("error getting weekly_minutes of the reservation's user: %v", err)
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// check the added time again, a booking may have claimed it since the check above
+	if txErr = checkPrinterAvailability(tx, r.PrinterId, r.Time_Complete, newTimeComplete, r.Id); txErr != nil {
+		return false, fmt.Errorf("%w: %w", ErrorReservationConflict, txErr)
 	}
 
-	refundedUserWeeklyMinutes := userWeeklyMinutes + minutesToRefund //add
-	updateSQL := `UPDATE users SET weekly_minutes = ? WHERE id = ?`
-	_, err = database.DB.Exec(updateSQL, refundedUserWeeklyMinutes, userId)
+	// only charge the user if they still have the minutes. EGN block bookings and series occurrences aren't charged.
+	if charged {
+		txErr = recordMinuteChange(tx, minuteChange{userId: r.UserId, delta: -request.AdditionalMins, entryType: LedgerDebit,
+			reservationId: r.Id, actorId: requesterId, note: fmt.Sprintf("extended by %d minutes", request.AdditionalMins)})
+		if errors.Is(txErr, ErrorInsufficientMinutes) {
+			return false, fmt.Errorf("%w: extending by %d minutes", ErrorInsufficientMinutes, request.AdditionalMins)
+		} else if txErr != nil {
+			return false, fmt.Errorf("error subtracting minutes from user: %v", txErr)
+		}
+	}
+
+	// only extend if the reservation hasn't ended in the meantime
+	result, err := tx.Exec("UPDATE reservations SET time_complete = ? WHERE id = ? AND is_active = TRUE", newTimeComplete, r.Id)
 	if err != nil {
-		return false, fmt.Errorf("error refunding weekly minutes to user: %v", err)
+		txErr = err
+		return false, fmt.Errorf("error extending reservation: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		txErr = fmt.Errorf("reservation %d ended before it could be extended", r.Id)
+		return false, txErr
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
-	//now that we have refunded the cancellation without errors, remove the reservation formally
-	CompleteReservation(request.PrinterId, request.ReservationId)
+	// move the completion and warning jobs to the new end time
+	r.Time_Complete = newTimeComplete
+	if err := trackActiveReservation(r); err != nil {
+		log.Printf("CRITICAL: reservation %d was extended but its completion could not be rescheduled: %v", r.Id, err)
+		return false, fmt.Errorf("error rescheduling reservation completion: %v", err)
+	}
+
+	log.Printf("Extended reservation %d by %d minutes, now completing at %s", r.Id, request.AdditionalMins, newTimeComplete.Format(time.RFC3339))
 	return true, nil
 }
+
+// given a reservationId, return whether it was booked without charging weekly_minutes (EGN block bookings and
+// series occurrences), in which case nothing is refunded when it is cancelled
+func isUnchargedReservation(reservationId int) (bool, error) {
+	var uncharged bool
+	err := database.DB.QueryRow("SELECT is_egn_reservation OR series_id IS NOT NULL FROM reservations WHERE id = ?", reservationId).Scan(&uncharged)
+	if err != nil {
+		return false, fmt.Errorf("error checking if reservation %d was charged: %v", reservationId, err)
+	}
+	return uncharged, nil
+}
+
+// how long after a reservation ends its user can still report the print as failed
+const printFailureReportWindow = 24 * time.Hour
+
+// returned (wrapped) when a reservation's outcome can't be reported as failed
+var ErrorCannotReportFailure = errors.New("reservation can't be reported as failed")
+
+type ReportPrintFailureRequest struct {
+	ReservationId int `json:"reservation_id"`
+}
+
+// given a reservationId, mark the reservation's print as failed. A running reservation is ended now; a finished one
+// can be reported up to a day after it ended. The refund follows the print_failed refund policy (none by default),
+// and reservations that were never charged aren't refunded. Only the reservation's user (or staff who can manage
+// reservations) can report it. Returns the number of minutes refunded.
+func ReportPrintFailure(request ReportPrintFailureRequest, requesterId int, canManage bool) (int, error) {
+	var r models.Reservation
+	var outcome sql.NullString
+	var uncharged bool
+	err := database.DB.QueryRow(`SELECT id, printerid, userId, time_reserved, time_complete, is_active, is_scheduled, outcome,
+								is_egn_reservation OR series_id IS NOT NULL FROM reservations WHERE id = ?`, request.ReservationId).Scan(
+		&r.Id, &r.PrinterId, &r.UserId, &r.Time_Reserved, &r.Time_Complete, &r.Is_Active, &r.Is_Scheduled, &outcome, &uncharged)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no reservation of ID %d exists", request.ReservationId)
+	} else if err != nil {
+		return 0, fmt.Errorf("error getting reservation: %v", err)
+	}
+
+	if !canManage && r.UserId != requesterId {
+		return 0, ErrorNotReservationOwner
+	}
+	if r.Is_Scheduled {
+		return 0, fmt.Errorf("%w: reservation %d hasn't started yet", ErrorCannotReportFailure, r.Id)
+	}
+	if !r.Is_Active {
+		if outcome.String != OutcomeSuccess {
+			return 0, fmt.Errorf("%w: reservation %d already ended as %s", ErrorCannotReportFailure, r.Id, outcome.String)
+		}
+		if time.Since(r.Time_Complete) > printFailureReportWindow {
+			return 0, fmt.Errorf("%w: failures have to be reported within %d hours of the reservation ending", ErrorCannotReportFailure,
+				int(printFailureReportWindow.Hours()))
+		}
+	}
+
+	policy, err := getRefundPolicy(EndReasonPrintFailed)
+	if err != nil && !errors.Is(err, ErrorUnknownReasonCode) {
+		return 0, err
+	}
+	refund := 0
+	if policy != nil && !uncharged {
+		refund = refundMinutes(policy, r.Time_Reserved, r.Time_Complete)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// only report it if nothing ended (or re-reported) the reservation in the meantime
+	var result sql.Result
+	if r.Is_Active {
+		result, err = tx.Exec(`UPDATE reservations SET is_active = FALSE, outcome = ?, end_reason = ?, refunded_minutes = ?, ended_at = ?
+							WHERE id = ? AND is_active = TRUE`, OutcomeFailed, EndReasonPrintFailed, refund, time.Now(), r.Id)
+	} else {
+		result, err = tx.Exec(`UPDATE reservations SET outcome = ?, end_reason = ?, refunded_minutes = refunded_minutes + ?
+							WHERE id = ? AND outcome = ?`, OutcomeFailed, EndReasonPrintFailed, refund, r.Id, OutcomeSuccess)
+	}
+	if err != nil {
+		txErr = err
+		return 0, fmt.Errorf("error recording failed print: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		txErr = fmt.Errorf("reservation %d changed while the failure was being reported, try again", r.Id)
+		return 0, txErr
+	}
+
+	if refund > 0 {
+		txErr = recordMinuteChange(tx, minuteChange{userId: r.UserId, delta: refund, entryType: LedgerRefund,
+			reservationId: r.Id, actorId: requesterId, note: "reported as a failed print"})
+		if txErr != nil {
+			return 0, fmt.Errorf("error refunding weekly minutes to user: %v", txErr)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	if r.Is_Active {
+		releaseReservationPrinter(r.PrinterId, r.Id)
+	}
+	log.Printf("Reservation %d reported as a failed print by user %d, refunded %d minutes", r.Id, requesterId, refund)
+	return refund, nil
+}
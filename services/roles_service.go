@@ -0,0 +1,281 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/util"
+	"log"
+	"strings"
+)
+
+// define reusable role errors
+var (
+	ErrorRoleNotFound      = errors.New("role not found")
+	ErrorRoleInUse         = errors.New("role is assigned to users")
+	ErrorBuiltInRole       = errors.New("built-in roles can't be changed this way")
+	ErrorUnknownPermission = errors.New("unknown permission")
+	ErrorLastAdmin         = errors.New("at least one user must keep the admin role")
+)
+
+// returns every role with its permissions and how many users have it
+func GetRoles() ([]models.Role, error) {
+	rows, err := database.DB.Query(`SELECT r.id, r.name, r.description, (SELECT COUNT(*) FROM users u WHERE u.role_id = r.id)
+									FROM roles r ORDER BY r.id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("error getting roles: %v", err)
+	}
+	roles := []models.Role{}
+	for rows.Next() {
+		var r models.Role
+		if err := rows.Scan(&r.Id, &r.Name, &r.Description, &r.User_Count); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning role: %v", err)
+		}
+		roles = append(roles, r)
+	}
+	rows.Close()
+
+	for i := range roles {
+		if roles[i].Permissions, err = rolePermissions(database.DB, &roles[i]); err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+// given a role's name, return the role with its permissions
+func getRole(db dbExecutor, name string) (*models.Role, error) {
+	var r models.Role
+	err := db.QueryRow("SELECT id, name, description FROM roles WHERE name = ?", name).Scan(&r.Id, &r.Name, &r.Description)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrorRoleNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting role: %v", err)
+	}
+	if r.Permissions, err = rolePermissions(db, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// given a role, return the permissions it grants. The admin role always has every permission.
+func rolePermissions(db dbExecutor, role *models.Role) ([]string, error) {
+	if role.Name == models.RoleAdmin {
+		return models.AllPermissions, nil
+	}
+	rows, err := db.Query("SELECT permission FROM role_permissions WHERE role_id = ? ORDER BY permission", role.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting permissions of role %s: %v", role.Name, err)
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, fmt.Errorf("error scanning permission: %v", err)
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// given a list of permissions from a request, return an error if any of them don't exist
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		known := false
+		for _, p := range models.AllPermissions {
+			if p == permission {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: %s", ErrorUnknownPermission, permission)
+		}
+	}
+	return nil
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// given a name and the permissions it grants, add a role and return it
+func CreateRole(request CreateRoleRequest) (*models.Role, error) {
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		return nil, fmt.Errorf("role name is required")
+	}
+	if err := validatePermissions(request.Permissions); err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, txErr := tx.Exec("INSERT INTO roles (name, description) VALUES (?, ?)", request.Name, request.Description)
+	if txErr != nil {
+		return nil, fmt.Errorf("error adding role (names must be unique): %v", txErr)
+	}
+	id, txErr := result.LastInsertId()
+	if txErr != nil {
+		return nil, fmt.Errorf("error getting role id: %v", txErr)
+	}
+	if txErr = insertRolePermissions(tx, int(id), request.Permissions); txErr != nil {
+		return nil, txErr
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return getRole(database.DB, request.Name)
+}
+
+type SetRolePermissionsRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+// given a roleId and a list of permissions, replace the role's permissions. Users with the role get the new
+// permissions the next time their access token is refreshed. The admin role can't be changed.
+func SetRolePermissions(roleId int, request SetRolePermissionsRequest) (*models.Role, error) {
+	if err := validatePermissions(request.Permissions); err != nil {
+		return nil, err
+	}
+
+	var name string
+	err := database.DB.QueryRow("SELECT name FROM roles WHERE id = ?", roleId).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrorRoleNotFound, roleId)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting role: %v", err)
+	}
+	if name == models.RoleAdmin {
+		return nil, fmt.Errorf("%w: the admin role always has every permission", ErrorBuiltInRole)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, txErr = tx.Exec("DELETE FROM role_permissions WHERE role_id = ?", roleId); txErr != nil {
+		return nil, fmt.Errorf("error clearing role permissions: %v", txErr)
+	}
+	if txErr = insertRolePermissions(tx, roleId, request.Permissions); txErr != nil {
+		return nil, txErr
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return getRole(database.DB, name)
+}
+
+// given a roleId and its permissions, add a role_permissions row for each one
+func insertRolePermissions(db dbExecutor, roleId int, permissions []string) error {
+	for _, permission := range permissions {
+		if _, err := db.Exec("INSERT OR IGNORE INTO role_permissions (role_id, permission) VALUES (?, ?)", roleId, permission); err != nil {
+			return fmt.Errorf("error adding permission %s: %v", permission, err)
+		}
+	}
+	return nil
+}
+
+// given a roleId, remove that role. The default roles and roles still assigned to users can't be removed.
+func DeleteRole(roleId int) error {
+	var name string
+	var userCount int
+	err := database.DB.QueryRow("SELECT name, (SELECT COUNT(*) FROM users WHERE role_id = roles.id) FROM roles WHERE id = ?", roleId).Scan(&name, &userCount)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %d", ErrorRoleNotFound, roleId)
+	} else if err != nil {
+		return fmt.Errorf("error getting role: %v", err)
+	}
+	if name == models.RoleUser || name == models.RoleLabAssistant || name == models.RoleAdmin {
+		return fmt.Errorf("%w: %s can't be removed", ErrorBuiltInRole, name)
+	}
+	if userCount > 0 {
+		return fmt.Errorf("%w: move its users to another role first", ErrorRoleInUse)
+	}
+
+	if _, err := database.DB.Exec("DELETE FROM role_permissions WHERE role_id = ?", roleId); err != nil {
+		return fmt.Errorf("error removing role permissions: %v", err)
+	}
+	if _, err := database.DB.Exec("DELETE FROM roles WHERE id = ?", roleId); err != nil {
+		return fmt.Errorf("error removing role: %v", err)
+	}
+	return nil
+}
+
+type SetUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// given a userId and a role's name, give the user that role. The users.admin flag is kept in step with the
+// admin role. The last admin can't be given another role. The user's sessions are ended, so they log in again
+// with tokens that carry the new role.
+func SetUserRole(userId int, request SetUserRoleRequest) error {
+	role, err := getRole(database.DB, request.Role)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, txErr := tx.Exec("UPDATE users SET role_id = ?, admin = ? WHERE id = ?", role.Id, role.Name == models.RoleAdmin, userId)
+	if txErr != nil {
+		return fmt.Errorf("error setting role of user: %v", txErr)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		txErr = ErrorUserNotFound
+		return txErr
+	}
+
+	var admins int
+	if txErr = tx.QueryRow("SELECT COUNT(*) FROM users u JOIN roles r ON r.id = u.role_id WHERE r.name = ?", models.RoleAdmin).Scan(&admins); txErr != nil {
+		return fmt.Errorf("error counting admins: %v", txErr)
+	}
+	if admins == 0 {
+		txErr = ErrorLastAdmin
+		return txErr
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	//the role and its permissions are in the user's tokens, so sessions issued under the old role can't carry on
+	if _, err = util.RevokeUserRefreshTokens(userId, util.RevokeRoleChanged); err != nil {
+		log.Printf("failed to revoke sessions of user %d after role change: %v", userId, err)
+	}
+	return nil
+}
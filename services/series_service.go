@@ -0,0 +1,639 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/scheduler"
+	"gin-api/util"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statuses stored in reservation_series.status
+const (
+	SeriesActive    = "active"
+	SeriesCancelled = "cancelled"
+)
+
+// dates in a series (start, end and exception dates) are plain calendar days in the lab's timezone
+const seriesDateLayout = "2006-01-02"
+
+// a series can't run for longer than this, so a typo in the end date can't book printers for years
+const maxSeriesLength = 366 * 24 * time.Hour
+
+// returned when a series id doesn't exist
+var ErrorSeriesNotFound = errors.New("reservation series not found")
+
+type ReservationSeriesRequest struct {
+	PrinterIds     []int    `json:"printer_ids"`
+	Weekday        int      `json:"weekday"`    // 0 is Sunday, 6 is Saturday
+	StartTime      string   `json:"start_time"` // "HH:MM" in the lab's timezone
+	TimeMins       int      `json:"time_mins"`
+	StartDate      string   `json:"start_date"` // "YYYY-MM-DD"
+	EndDate        string   `json:"end_date"`   // "YYYY-MM-DD", inclusive
+	ExceptionDates []string `json:"exception_dates"`
+}
+
+// one printer booked for one week of a series
+type seriesOccurrence struct {
+	printerId int
+	start     time.Time
+	end       time.Time
+}
+
+// validate the request's recurrence rule and expand it into one occurrence per printer per week, skipping
+// exception dates and anything that has already started
+func (request ReservationSeriesRequest) occurrences() ([]seriesOccurrence, error) {
+	if len(request.PrinterIds) == 0 {
+		return nil, fmt.Errorf("at least one printer is required")
+	}
+	seen := make(map[int]bool)
+	for _, printerId := range request.PrinterIds {
+		if seen[printerId] {
+			return nil, fmt.Errorf("printer %d is listed more than once", printerId)
+		}
+		seen[printerId] = true
+	}
+	if request.Weekday < 0 || request.Weekday > 6 {
+		return nil, fmt.Errorf("weekday must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	// an occurrence can't run into the next week's occurrence on the same printer
+	if request.TimeMins <= 0 || request.TimeMins >= 7*24*60 {
+		return nil, fmt.Errorf("series length must be a positive number of minutes shorter than a week")
+	}
+	startMinutes, err := parseClockTime(request.StartTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start_time: %v", err)
+	}
+
+	loc := util.LabLocation()
+	startDate, err := time.ParseInLocation(seriesDateLayout, request.StartDate, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start_date: %v", err)
+	}
+	endDate, err := time.ParseInLocation(seriesDateLayout, request.EndDate, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end_date: %v", err)
+	}
+	if endDate.Before(startDate) {
+		return nil, fmt.Errorf("end_date must not be before start_date")
+	}
+	if endDate.Sub(startDate) > maxSeriesLength {
+		return nil, fmt.Errorf("a series can't run for more than a year")
+	}
+
+	exceptions := make(map[string]bool)
+	for _, date := range request.ExceptionDates {
+		if _, err := time.ParseInLocation(seriesDateLayout, date, loc); err != nil {
+			return nil, fmt.Errorf("invalid exception date: %v", err)
+		}
+		exceptions[date] = true
+	}
+
+	// first matching weekday on or after the start date, then every 7 calendar days (not 168 hours, so DST doesn't shift it)
+	day := startDate.AddDate(0, 0, (request.Weekday-int(startDate.Weekday())+7)%7)
+	now := time.Now()
+	var occurrences []seriesOccurrence
+	for ; !day.After(endDate); day = day.AddDate(0, 0, 7) {
+		if exceptions[day.Format(seriesDateLayout)] {
+			continue
+		}
+		start := time.Date(day.Year(), day.Month(), day.Day(), startMinutes/60, startMinutes%60, 0, 0, loc)
+		if !start.After(now) {
+			continue
+		}
+		end := start.Add(time.Duration(request.TimeMins) * time.Minute)
+		for _, printerId := range request.PrinterIds {
+			occurrences = append(occurrences, seriesOccurrence{printerId: printerId, start: start, end: end})
+		}
+	}
+	return occurrences, nil
+}
+
+// given a recurrence rule, book every upcoming occurrence of it under the given (admin) user. Occurrences are
+// ordinary upcoming reservations, so their printers are turned on and off by the same start and completion jobs.
+// They are not charged to weekly_minutes. If any occurrence overlaps an existing booking nothing is booked.
+func CreateReservationSeries(request ReservationSeriesRequest, userId int) (*models.ReservationSeries, error) {
+	occurrences, err := request.occurrences()
+	if err != nil {
+		return nil, err
+	}
+	if len(occurrences) == 0 {
+		return nil, fmt.Errorf("the series has no upcoming occurrences")
+	}
+	if err := checkPrintersExist(request.PrinterIds); err != nil {
+		return nil, err
+	}
+	if err := checkSeriesAvailability(occurrences, 0); err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	insertSQL := `INSERT INTO reservation_series (user_id, printer_ids, weekday, start_time, time_mins, start_date, end_date, exception_dates, status)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(insertSQL, userId, joinInts(request.PrinterIds), request.Weekday, request.StartTime, request.TimeMins,
+		request.StartDate, request.EndDate, strings.Join(request.ExceptionDates, ","), SeriesActive)
+	if err != nil {
+		txErr = err
+		return nil, fmt.Errorf("error inserting reservation series: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		txErr = err
+		return nil, fmt.Errorf("error getting id of reservation series: %v", err)
+	}
+	seriesId := int(id)
+
+	reservations, err := insertSeriesOccurrences(tx, seriesId, userId, occurrences)
+	if err != nil {
+		txErr = err
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	trackSeriesOccurrences(reservations)
+	log.Printf("Created reservation series %d with %d occurrence(s)", seriesId, len(reservations))
+	return GetReservationSeriesById(seriesId)
+}
+
+// returns every reservation series, newest first
+func GetReservationSeries() ([]models.ReservationSeries, error) {
+	rows, err := database.DB.Query(`SELECT id, user_id, printer_ids, weekday, start_time, time_mins, start_date, end_date, exception_dates, status, created_at
+									FROM reservation_series ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("error getting reservation series: %v", err)
+	}
+	defer rows.Close()
+
+	series := []models.ReservationSeries{}
+	for rows.Next() {
+		s, err := scanReservationSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, *s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return series, nil
+}
+
+// given a seriesId, return that series
+func GetReservationSeriesById(seriesId int) (*models.ReservationSeries, error) {
+	row := database.DB.QueryRow(`SELECT id, user_id, printer_ids, weekday, start_time, time_mins, start_date, end_date, exception_dates, status, created_at
+								FROM reservation_series WHERE id = ?`, seriesId)
+	s, err := scanReservationSeries(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: no series of ID %d exists", ErrorSeriesNotFound, seriesId)
+	}
+	return s, err
+}
+
+// given a seriesId, return its running and upcoming occurrences, soonest first
+func GetSeriesOccurrences(seriesId int) ([]models.ReservationDTO, error) {
+	if _, err := GetReservationSeriesById(seriesId); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT
+			r.id, r.printerId, p.name AS printer_name, r.userId, u.username,
+			r.time_reserved, r.time_complete, r.is_active, r.is_scheduled
+		FROM reservations r
+		JOIN printers p ON r.printerId = p.id
+		JOIN users u ON r.userId = u.id
+		WHERE r.series_id = ? AND (r.is_active = 1 OR r.is_scheduled = 1)
+		ORDER BY r.time_reserved ASC, r.printerId ASC
+	`
+	rows, err := database.DB.Query(query, seriesId)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	reservations := []models.ReservationDTO{}
+	for rows.Next() {
+		var r models.ReservationDTO
+		if err := rows.Scan(
+			&r.Id, &r.PrinterId, &r.PrinterName, &r.UserId, &r.Username,
+			&r.Time_Reserved, &r.Time_Complete, &r.Is_Active, &r.Is_Scheduled,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		reservations = append(reservations, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return reservations, nil
+}
+
+// given a seriesId and a new recurrence rule, replace the rule of the whole series. Every occurrence that hasn't
+// started yet is cancelled and re-booked from the new rule; an occurrence that is already running is left alone.
+func UpdateReservationSeries(seriesId int, request ReservationSeriesRequest) (*models.ReservationSeries, error) {
+	series, err := GetReservationSeriesById(seriesId)
+	if err != nil {
+		return nil, err
+	}
+	if series.Status != SeriesActive {
+		return nil, fmt.Errorf("series %d has been cancelled", seriesId)
+	}
+
+	occurrences, err := request.occurrences()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPrintersExist(request.PrinterIds); err != nil {
+		return nil, err
+	}
+	if err := checkSeriesAvailability(occurrences, seriesId); err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	cancelledIds, err := cancelUpcomingSeriesOccurrences(tx, seriesId)
+	if err != nil {
+		txErr = err
+		return nil, err
+	}
+
+	updateSQL := `UPDATE reservation_series SET printer_ids = ?, weekday = ?, start_time = ?, time_mins = ?, start_date = ?, end_date = ?, exception_dates = ?
+				WHERE id = ?`
+	_, err = tx.Exec(updateSQL, joinInts(request.PrinterIds), request.Weekday, request.StartTime, request.TimeMins,
+		request.StartDate, request.EndDate, strings.Join(request.ExceptionDates, ","), seriesId)
+	if err != nil {
+		txErr = err
+		return nil, fmt.Errorf("error updating reservation series: %v", err)
+	}
+
+	reservations, err := insertSeriesOccurrences(tx, seriesId, series.UserId, occurrences)
+	if err != nil {
+		txErr = err
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	forgetUpcomingReservations(cancelledIds)
+	trackSeriesOccurrences(reservations)
+	log.Printf("Updated reservation series %d, replaced %d upcoming occurrence(s) with %d", seriesId, len(cancelledIds), len(reservations))
+	return GetReservationSeriesById(seriesId)
+}
+
+// given a seriesId, cancel the whole series. Upcoming occurrences are cancelled and a running occurrence is ended now.
+func CancelReservationSeries(seriesId int) error {
+	series, err := GetReservationSeriesById(seriesId)
+	if err != nil {
+		return err
+	}
+	if series.Status != SeriesActive {
+		return fmt.Errorf("series %d has already been cancelled", seriesId)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	cancelledIds, err := cancelUpcomingSeriesOccurrences(tx, seriesId)
+	if err != nil {
+		txErr = err
+		return err
+	}
+	if _, txErr = tx.Exec("UPDATE reservation_series SET status = ? WHERE id = ?", SeriesCancelled, seriesId); txErr != nil {
+		return fmt.Errorf("error cancelling reservation series: %v", txErr)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	forgetUpcomingReservations(cancelledIds)
+
+	// end whatever occurrence is printing right now
+	rows, err := database.DB.Query("SELECT id, printerid FROM reservations WHERE series_id = ? AND is_active = TRUE", seriesId)
+	if err != nil {
+		return fmt.Errorf("error getting running occurrences of series %d: %v", seriesId, err)
+	}
+	var running []models.Reservation
+	for rows.Next() {
+		var r models.Reservation
+		if err := rows.Scan(&r.Id, &r.PrinterId); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning running occurrence: %v", err)
+		}
+		running = append(running, r)
+	}
+	rows.Close()
+	for _, r := range running {
+		CompleteReservation(r.PrinterId, r.Id)
+	}
+
+	log.Printf("Cancelled reservation series %d (%d upcoming, %d running occurrence(s))", seriesId, len(cancelledIds), len(running))
+	return nil
+}
+
+type UpdateSeriesOccurrenceRequest struct {
+	StartTime time.Time `json:"start_time"`
+	TimeMins  int       `json:"time_mins"`
+}
+
+// given the reservationId of an upcoming series occurrence, move just that occurrence to a new start time and length.
+// The rest of the series is unchanged. Running occurrences can be lengthened with ExtendReservation instead.
+func UpdateSeriesOccurrence(reservationId int, request UpdateSeriesOccurrenceRequest) error {
+	r, err := getSeriesOccurrence(reservationId)
+	if err != nil {
+		return err
+	}
+	if !r.Is_Scheduled {
+		return fmt.Errorf("only occurrences that haven't started yet can be edited")
+	}
+	if request.TimeMins <= 0 {
+		return fmt.Errorf("reservation length must be a positive number of minutes")
+	}
+	if !request.StartTime.After(time.Now()) {
+		return fmt.Errorf("occurrence start time %s is in the past", request.StartTime.Format(time.RFC3339))
+	}
+	newTimeComplete := request.StartTime.Add(time.Duration(request.TimeMins) * time.Minute)
+
+	if err := checkPrinterAvailability(database.DB, r.PrinterId, request.StartTime, newTimeComplete, r.Id); err != nil {
+		return err
+	}
+
+	// only move the occurrence if its start job hasn't run in the meantime
+	result, err := database.DB.Exec("UPDATE reservations SET time_reserved = ?, time_complete = ? WHERE id = ? AND is_scheduled = TRUE",
+		request.StartTime, newTimeComplete, r.Id)
+	if err != nil {
+		return fmt.Errorf("error updating occurrence: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		return fmt.Errorf("occurrence %d started before it could be edited", r.Id)
+	}
+
+	r.Time_Reserved = request.StartTime
+	r.Time_Complete = newTimeComplete
+	if err := trackUpcomingReservation(*r); err != nil {
+		log.Printf("CRITICAL: occurrence %d was moved but its start could not be rescheduled: %v", r.Id, err)
+		return fmt.Errorf("error rescheduling occurrence start: %v", err)
+	}
+
+	log.Printf("Moved occurrence %d of series %d to %s", r.Id, r.Series_Id, request.StartTime.Format(time.RFC3339))
+	return nil
+}
+
+// given the reservationId of a series occurrence, cancel just that occurrence (ending it now if it is running).
+// Its date is added to the series' exception dates so editing the whole series later doesn't bring it back.
+func CancelSeriesOccurrence(reservationId int) error {
+	r, err := getSeriesOccurrence(reservationId)
+	if err != nil {
+		return err
+	}
+
+	if r.Is_Scheduled {
+		if _, err := cancelScheduledReservation(r.Id, r.UserId, r.Time_Reserved, r.Time_Complete, 0); err != nil {
+			return err
+		}
+	} else if r.Is_Active {
+		CompleteReservation(r.PrinterId, r.Id)
+	} else {
+		return fmt.Errorf("occurrence %d is already over", r.Id)
+	}
+
+	series, err := GetReservationSeriesById(r.Series_Id)
+	if err != nil {
+		return err
+	}
+	date := r.Time_Reserved.In(util.LabLocation()).Format(seriesDateLayout)
+	for _, exception := range series.Exception_Dates {
+		if exception == date {
+			return nil
+		}
+	}
+	exceptions := append(series.Exception_Dates, date)
+	_, err = database.DB.Exec("UPDATE reservation_series SET exception_dates = ? WHERE id = ?", strings.Join(exceptions, ","), r.Series_Id)
+	if err != nil {
+		return fmt.Errorf("error adding exception date to series %d: %v", r.Series_Id, err)
+	}
+	return nil
+}
+
+// given a reservationId, return the reservation if it is an occurrence of a series
+func getSeriesOccurrence(reservationId int) (*models.Reservation, error) {
+	var r models.Reservation
+	err := database.DB.QueryRow(`SELECT id, printerid, userId, time_reserved, time_complete, is_active, is_scheduled, COALESCE(series_id, 0)
+								FROM reservations WHERE id = ?`, reservationId).Scan(
+		&r.Id, &r.PrinterId, &r.UserId, &r.Time_Reserved, &r.Time_Complete, &r.Is_Active, &r.Is_Scheduled, &r.Series_Id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no reservation of ID %d exists", reservationId)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting reservation: %v", err)
+	}
+	if r.Series_Id == 0 {
+		return nil, fmt.Errorf("reservation %d is not part of a series", reservationId)
+	}
+	return &r, nil
+}
+
+// given a list of occurrences, return an error if any of them overlaps an active or upcoming reservation.
+// Upcoming occurrences of ignoreSeriesId are skipped since they are about to be replaced.
+func checkSeriesAvailability(occurrences []seriesOccurrence, ignoreSeriesId int) error {
+	querySQL := `SELECT printerid, time_reserved, time_complete FROM reservations
+				WHERE (is_active = TRUE OR is_scheduled = TRUE) AND NOT (is_scheduled = TRUE AND COALESCE(series_id, 0) = ?)`
+	rows, err := database.DB.Query(querySQL, ignoreSeriesId)
+	if err != nil {
+		return fmt.Errorf("failed to check printer bookings: %v", err)
+	}
+	defer rows.Close()
+
+	type booking struct {
+		start time.Time
+		end   time.Time
+	}
+	bookings := make(map[int][]booking)
+	for rows.Next() {
+		var printerId int
+		var b booking
+		if err := rows.Scan(&printerId, &b.start, &b.end); err != nil {
+			return fmt.Errorf("failed to scan printer booking: %v", err)
+		}
+		bookings[printerId] = append(bookings[printerId], b)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %v", err)
+	}
+
+	//compared here rather than in SQL since stored timestamps may carry different UTC offsets
+	for _, o := range occurrences {
+		for _, b := range bookings[o.printerId] {
+			if o.start.Before(b.end) && b.start.Before(o.end) {
+				return fmt.Errorf("%w: printer %d is already booked from %s to %s", ErrorPrinterBooked, o.printerId,
+					b.start.Format("Jan 2 3:04 PM"), b.end.Format("Jan 2 3:04 PM"))
+			}
+		}
+	}
+	return nil
+}
+
+// given a list of printer ids, return an error if any of them doesn't exist or has been retired. Printers in
+// maintenance or offline are allowed, occurrences that come up while they are still out of service are cancelled.
+func checkPrintersExist(printerIds []int) error {
+	for _, printerId := range printerIds {
+		var status string
+		err := database.DB.QueryRow("SELECT status FROM printers WHERE id = ?", printerId).Scan(&status)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("printer with id %d not found", printerId)
+		} else if err != nil {
+			return fmt.Errorf("failed to get printer details: %v", err)
+		}
+		if status == PrinterRetired {
+			return fmt.Errorf("%w: printer %d status is %s", ErrorPrinterUnavailable, printerId, status)
+		}
+	}
+	return nil
+}
+
+// insert each occurrence as an upcoming reservation of the series (within the given transaction)
+func insertSeriesOccurrences(tx *sql.Tx, seriesId int, userId int, occurrences []seriesOccurrence) ([]models.Reservation, error) {
+	reservations := make([]models.Reservation, 0, len(occurrences))
+	for _, o := range occurrences {
+		result, err := tx.Exec(
+			"INSERT INTO reservations (printerid, userid, time_reserved, time_complete, is_active, is_scheduled, series_id) values (?, ?, ?, ?, FALSE, TRUE, ?)",
+			o.printerId, userId, o.start, o.end, seriesId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert occurrence on printer %d: %v", o.printerId, err)
+		}
+		reservationId, err := result.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reservation id: %v", err)
+		}
+		reservations = append(reservations, models.Reservation{
+			Id:            int(reservationId),
+			PrinterId:     o.printerId,
+			UserId:        userId,
+			Time_Reserved: o.start,
+			Time_Complete: o.end,
+			Is_Scheduled:  true,
+			Series_Id:     seriesId,
+		})
+	}
+	return reservations, nil
+}
+
+// mark every upcoming occurrence of a series as no longer scheduled (within the given transaction) and return their ids
+func cancelUpcomingSeriesOccurrences(tx *sql.Tx, seriesId int) ([]int, error) {
+	rows, err := tx.Query("SELECT id FROM reservations WHERE series_id = ? AND is_scheduled = TRUE", seriesId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting upcoming occurrences of series %d: %v", seriesId, err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning occurrence id: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec("UPDATE reservations SET is_scheduled = FALSE WHERE series_id = ? AND is_scheduled = TRUE", seriesId); err != nil {
+		return nil, fmt.Errorf("error cancelling upcoming occurrences of series %d: %v", seriesId, err)
+	}
+	return ids, nil
+}
+
+// schedule the start job of each new occurrence. The occurrences are in the database already, so startup
+// recovery picks up any that fail here.
+func trackSeriesOccurrences(reservations []models.Reservation) {
+	for _, reservation := range reservations {
+		if err := trackUpcomingReservation(reservation); err != nil {
+			log.Printf("CRITICAL: failed to schedule start of series occurrence %d: %v", reservation.Id, err)
+		}
+	}
+}
+
+// cancel the start jobs of reservations that were cancelled and drop them from the upcoming manager
+func forgetUpcomingReservations(reservationIds []int) {
+	for _, id := range reservationIds {
+		if err := scheduler.Cancel(scheduler.JobStartReservation, id); err != nil {
+			log.Printf("failed to cancel start job of reservation %d: %v", id, err)
+		}
+		upcomingManager.Mutex.Lock()
+		delete(upcomingManager.Reservations, id)
+		upcomingManager.Mutex.Unlock()
+	}
+}
+
+// scan a reservation_series row, splitting the comma separated printer ids and exception dates
+func scanReservationSeries(row interface{ Scan(...interface{}) error }) (*models.ReservationSeries, error) {
+	var s models.ReservationSeries
+	var printerIds, exceptionDates string
+	err := row.Scan(&s.Id, &s.UserId, &printerIds, &s.Weekday, &s.Start_Time, &s.Time_Mins, &s.Start_Date, &s.End_Date,
+		&exceptionDates, &s.Status, &s.Created_At)
+	if err == sql.ErrNoRows {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("error scanning reservation series: %v", err)
+	}
+
+	s.PrinterIds = []int{}
+	for _, part := range strings.Split(printerIds, ",") {
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("series %d has an invalid printer id %q", s.Id, part)
+		}
+		s.PrinterIds = append(s.PrinterIds, id)
+	}
+	s.Exception_Dates = []string{}
+	if exceptionDates != "" {
+		s.Exception_Dates = strings.Split(exceptionDates, ",")
+	}
+	return &s, nil
+}
+
+// join ints into a comma separated string, e.g. []int{5, 6} into "5,6"
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
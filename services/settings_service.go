@@ -6,6 +6,7 @@ import (
 	"gin-api/models"
 	"gin-api/util"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -28,6 +29,23 @@ type SetSettingsRequest struct {
 // directly set all time settings values both in the global obj and the database to avoid desync
 func SetTimeSettings(request SetSettingsRequest) error {
 
+	//reject settings the reservation policy couldn't evaluate
+	if _, _, err := parseDayNightStarts(request.TimeSettings.DayStart, request.TimeSettings.NightStart); err != nil {
+		return err
+	}
+	if request.TimeSettings.Timezone == "" { //timezone left out of the request, keep the current one
+		if !util.Settings.TimeSettings.UpToDate {
+			if err := util.ImportSettingsFromDB(); err != nil {
+				return err
+			}
+		}
+		request.TimeSettings.Timezone = util.Settings.TimeSettings.Timezone
+	}
+	if _, err := time.LoadLocation(request.TimeSettings.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", request.TimeSettings.Timezone, err)
+	}
+	timezoneChanged := request.TimeSettings.Timezone != util.Settings.TimeSettings.Timezone
+
 	//update global obj
 	util.Settings.TimeSettings.WeekdayPrintTime.DayMaxPrintHours = request.TimeSettings.WeekdayPrintTime.DayMaxPrintHours
 	util.Settings.TimeSettings.WeekdayPrintTime.NightMaxPrintHours = request.TimeSettings.WeekdayPrintTime.NightMaxPrintHours
@@ -36,6 +54,7 @@ func SetTimeSettings(request SetSettingsRequest) error {
 	util.Settings.TimeSettings.DayStart = request.TimeSettings.DayStart
 	util.Settings.TimeSettings.NightStart = request.TimeSettings.NightStart
 	util.Settings.TimeSettings.DefaultUserWeeklyHours = request.TimeSettings.DefaultUserWeeklyHours
+	util.Settings.TimeSettings.Timezone = request.TimeSettings.Timezone
 
 	//if somehow UpToDate bool is not set yet, set it to true
 	util.Settings.TimeSettings.UpToDate = true
@@ -43,7 +62,8 @@ func SetTimeSettings(request SetSettingsRequest) error {
 	updateSQL := `UPDATE settings SET 
 				day_max_print_hours_week = ?, night_max_print_hours_week = ?,
 				day_max_print_hours_weekend = ?, night_max_print_hours_weekend = ?,
-				day_start = ?, night_start = ?, default_user_weekly_hours = ?
+				day_start = ?, night_start = ?, default_user_weekly_hours = ?,
+				timezone = ?
 				WHERE name = "default"`
 
 	//update db
@@ -54,10 +74,16 @@ func SetTimeSettings(request SetSettingsRequest) error {
 		request.TimeSettings.WeekendPrintTime.NightMaxPrintHours,
 		request.TimeSettings.DayStart,
 		request.TimeSettings.NightStart,
-		request.TimeSettings.DefaultUserWeeklyHours)
+		request.TimeSettings.DefaultUserWeeklyHours,
+		request.TimeSettings.Timezone)
 	if err != nil {
 		return fmt.Errorf("error updating settings in db: %v", err)
 	}
+
+	//the weekly reset is at a time of day in the lab's timezone, so it moves with the timezone
+	if timezoneChanged {
+		return rescheduleWeeklyReset()
+	}
 	return nil
 }
 
@@ -71,25 +97,69 @@ func GetPrinterSettings() (models.PrinterSettings, error) {
 	return util.Settings.PrinterSettings, err
 }
 
-// sets the printer settings passed in by the request. Currently the only printer
-// setting is the max active reservations. Logic for other printer settings should be
+// sets the printer settings passed in by the request: the max active reservations and
+// the check-in window (0 turns check-in off). Logic for other printer settings should be
 // added here and request body should be added to.
-func SetPrinterSettings(newMax int) error {
+func SetPrinterSettings(newMax int, checkInMinutes int) error {
 	if newMax <= 0 {
 		return fmt.Errorf("max reservations must be a positive number")
 	}
+	if checkInMinutes < 0 {
+		return fmt.Errorf("check-in minutes can't be negative")
+	}
 	//update global obj
 	util.Settings.PrinterSettings.MaxActiveReservations = newMax
+	util.Settings.PrinterSettings.CheckInMinutes = checkInMinutes
 
 	//update in database
-	updateSQL := `UPDATE settings SET max_active_reservations = ? WHERE name = "default"`
-	database.DB.Exec(updateSQL, newMax)
+	updateSQL := `UPDATE settings SET max_active_reservations = ?, check_in_minutes = ? WHERE name = "default"`
+	database.DB.Exec(updateSQL, newMax, checkInMinutes)
 
 	//raise upToDate flag for printerSettings
 	util.Settings.PrinterSettings.UpToDate = true
 	return nil
 }
 
+// get the card settings from the global obj if it is up to date.
+// If it is not up to date, import the settings from the DB and then get them.
+func GetCardSettings() (models.CardSettings, error) {
+	var err error = nil //no error by default
+	if !util.Settings.CardSettings.UpToDate {
+		err = util.ImportSettingsFromDB()
+	}
+	return util.Settings.CardSettings, err
+}
+
+// sets which card formats scans are read as and the order they are tried in. A scan is read as the first
+// format that recognises it, so formats that can look alike (an all-digit NFC UID and a barcode) should be
+// listed in the order the lab's readers need.
+func SetCardSettings(request models.CardSettings) error {
+	if len(request.Formats) == 0 {
+		return fmt.Errorf("at least one card format is required")
+	}
+	seen := map[string]bool{}
+	for _, name := range request.Formats {
+		if !util.IsCardFormat(name) {
+			return fmt.Errorf("unknown card format %q, must be one of %s", name, strings.Join(util.CardFormatNames(), ", "))
+		}
+		if seen[name] {
+			return fmt.Errorf("card format %s is listed twice", name)
+		}
+		seen[name] = true
+	}
+
+	//update in database
+	updateSQL := `UPDATE settings SET card_formats = ? WHERE name = "default"`
+	if _, err := database.DB.Exec(updateSQL, strings.Join(request.Formats, ",")); err != nil {
+		return fmt.Errorf("error updating settings in db: %v", err)
+	}
+
+	//update global obj
+	util.Settings.CardSettings.Formats = request.Formats
+	util.Settings.CardSettings.UpToDate = true
+	return nil
+}
+
 type ExportDbToUsbRequest struct {
 	Table string `json:"table"`
 }
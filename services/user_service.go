@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"gin-api/database"
 	"gin-api/models"
+	"gin-api/scheduler"
 	"gin-api/util"
+	"log"
+	"strings"
 	"time"
 )
 
@@ -13,15 +16,24 @@ type CreateUserRequest struct {
 	Scanner_Message string `json:"scanner_message"`
 	Trained         bool   `json:"trained"`
 	Admin           bool   `json:"admin"`
+	Role            string `json:"role"`     // optional, defaults to admin or user based on Admin
+	Username        string `json:"username"` // required for cards that don't carry the cardholder's name
 }
 
-//Given a card scanner raw input, trained bool, and admin bool, create a user and add it to user table
-func CreateUser(createUserRequest CreateUserRequest) (bool, error) {
+//Given a card scanner raw input, trained bool, a role (or admin bool) and the admin creating the user, create a
+//user and add it to user table. The card becomes the user's first credential, the user's id is not the card number.
+func CreateUser(createUserRequest CreateUserRequest, adminId int) (bool, error) {
 
 	cardData, err := util.ParseScannerString(createUserRequest.Scanner_Message)
 	if err != nil {
 		return false, fmt.Errorf("error parsing card data: %v", err)
 	}
+	if username := strings.TrimSpace(createUserRequest.Username); username != "" {
+		cardData.Username = username
+	}
+	if cardData.Username == "" {
+		return false, fmt.Errorf("a username is required for %s cards", cardData.Format)
+	}
 	
 	//check for existing user
 	var existingID int
@@ -38,15 +50,56 @@ func CreateUser(createUserRequest CreateUserRequest) (bool, error) {
 		return false, fmt.Errorf("user with username '%s' already exists", cardData.Username)
 	}
 
-	//add user
-	insertSQL := `INSERT INTO users (id, username, has_training, admin) VALUES (?, ?, ?, ?)`
-	_, err = database.DB.Exec(insertSQL, cardData.Id, cardData.Username, createUserRequest.Trained, createUserRequest.Admin)
+	role, err := getRole(database.DB, createUserRequest.roleName())
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := database.DB.Begin()
 	if err != nil {
-		return false, fmt.Errorf("could not add user: %v", err)
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	//add user
+	insertSQL := `INSERT INTO users (username, has_training, admin, role_id) VALUES (?, ?, ?, ?)`
+	result, txErr := tx.Exec(insertSQL, cardData.Username, createUserRequest.Trained, role.Name == models.RoleAdmin, role.Id)
+	if txErr != nil {
+		return false, fmt.Errorf("could not add user: %v", txErr)
+	}
+	userId, txErr := result.LastInsertId()
+	if txErr != nil {
+		return false, fmt.Errorf("could not get new user id: %v", txErr)
+	}
+	if _, txErr = insertCredential(tx, int(userId), cardData, "", adminId); txErr != nil {
+		return false, txErr
+	}
+
+	if txErr = tx.Commit(); txErr != nil {
+		return false, fmt.Errorf("error committing transaction: %v", txErr)
+	}
+	if err := openMinuteLedger(database.DB, int(userId)); err != nil {
+		log.Printf("%v", err)
 	}
 	return true, nil
 }
 
+//return the name of the role a new user is given
+func (r CreateUserRequest) roleName() string {
+	if r.Role != "" {
+		return r.Role
+	}
+	if r.Admin {
+		return models.RoleAdmin
+	}
+	return models.RoleUser
+}
+
 //Given a userId, toggle that user's has_training bool in the users table
 func SetUserTrained(userId int) error {
 
@@ -77,7 +130,8 @@ func GetUserReservations(userId int) ([]models.ReservationDTO, error) {
 	querySQL := `
 		SELECT 
 			r.id, r.userId, u.username, r.time_reserved, r.time_complete, 
-			r.printerid, p.name AS printer_name, r.is_active
+			r.printerid, p.name AS printer_name, r.is_active, r.is_scheduled, r.is_egn_reservation,
+			COALESCE(r.outcome, ''), COALESCE(r.end_reason, ''), r.refunded_minutes
 		FROM reservations r
 		JOIN users u ON r.userId = u.id
 		JOIN printers p ON r.printerid = p.id
@@ -96,7 +150,8 @@ func GetUserReservations(userId int) ([]models.ReservationDTO, error) {
 		err := rows.Scan(
 			&reservation.Id, &reservation.UserId, &reservation.Username, &reservation.Time_Reserved,
 			&reservation.Time_Complete, &reservation.PrinterId, &reservation.PrinterName,
-			&reservation.Is_Active,
+			&reservation.Is_Active, &reservation.Is_Scheduled, &reservation.Is_Egn_Reservation,
+			&reservation.Outcome, &reservation.End_Reason, &reservation.Refunded_Minutes,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning reservation: %v", err)
@@ -114,7 +169,7 @@ func GetActiveUserReservations(userId int) ([]models.ReservationDTO, error) {
 	querySQL := `
 		SELECT 
 			r.id, r.userId, u.username, r.time_reserved, r.time_complete, 
-			r.printerid, p.name AS printer_name, r.is_active
+			r.printerid, p.name AS printer_name, r.is_active, r.is_egn_reservation
 		FROM reservations r
 		JOIN users u ON r.userId = u.id
 		JOIN printers p ON r.printerid = p.id
@@ -133,7 +188,7 @@ func GetActiveUserReservations(userId int) ([]models.ReservationDTO, error) {
 		err := rows.Scan(
 			&reservation.Id, &reservation.UserId, &reservation.Username, &reservation.Time_Reserved,
 			&reservation.Time_Complete, &reservation.PrinterId, &reservation.PrinterName,
-			&reservation.Is_Active,
+			&reservation.Is_Active, &reservation.Is_Egn_Reservation,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning reservation: %v", err)
@@ -148,8 +203,9 @@ func GetActiveUserReservations(userId int) ([]models.ReservationDTO, error) {
 //given a userId, return a user object with all user data
 func GetUserById(userID int) (*models.UserData, error) {
 	var user models.UserData
-	querySQL := `SELECT id, username, has_training, admin, has_executive_access, ban_time_end, weekly_minutes FROM users WHERE id = ?`
-	err := database.DB.QueryRow(querySQL, userID).Scan(&user.Id, &user.Username, &user.Trained, &user.Admin, &user.Has_Executive_Access, &user.Ban_Time_End, &user.Weekly_Minutes)
+	querySQL := `SELECT id, username, has_training, admin, has_executive_access, is_egn_lab, ban_time_end, weekly_minutes, no_show_count, COALESCE(quota_tier_id, 0),
+				COALESCE((SELECT name FROM roles WHERE id = users.role_id), 'user') FROM users WHERE id = ?`
+	err := database.DB.QueryRow(querySQL, userID).Scan(&user.Id, &user.Username, &user.Trained, &user.Admin, &user.Has_Executive_Access, &user.Is_Egn_Lab, &user.Ban_Time_End, &user.Weekly_Minutes, &user.No_Show_Count, &user.Quota_Tier_Id, &user.Role)
 	if err != nil {
 		return nil, fmt.Errorf("error getting user from db: %v", err)
 	}
@@ -178,24 +234,35 @@ func SetUserExecutiveAccess(userId int) error {
 	return nil
 }
 
-type AddUserWeeklyMinutesRequest struct {
-	Minutes int `json:"minutes"`
-}
+//given a userId, toggle the user's is_egn_lab bool in the users table
+func SetUserEgnLab(userId int) error {
 
-//given a userId and a number of minutes, add those minutes to the user's weekly_minutes
-func AddUserWeeklyMinutes(id int, request AddUserWeeklyMinutesRequest) error {
-	var currentWeeklyMinutes int
+	var currentEgnLab bool
 
-	querySQL := `SELECT weekly_minutes FROM users WHERE id = ?`
-	err := database.DB.QueryRow(querySQL, id).Scan(&currentWeeklyMinutes)
+	querySQL := `SELECT is_egn_lab FROM users WHERE id = ?`
+	err := database.DB.QueryRow(querySQL, userId).Scan(&currentEgnLab)
 	if err != nil {
-		return fmt.Errorf("error getting user weekly minutes from db: %v", err)
+		return fmt.Errorf("error getting user EGN lab status from db: %v", err)
 	}
 
-	newWeeklyMinutes := currentWeeklyMinutes + request.Minutes
+	newEgnLab := !currentEgnLab
+
+	updateSQL := `UPDATE users SET is_egn_lab = ? WHERE id = ?`
+	_, err = database.DB.Exec(updateSQL, newEgnLab, userId)
+	if err != nil {
+		return fmt.Errorf("error updating user EGN lab status: %v", err)
+	}
+
+	return nil
+}
+
+type AddUserWeeklyMinutesRequest struct {
+	Minutes int `json:"minutes"`
+}
 
-	updateSQL := `UPDATE users SET weekly_minutes = ? WHERE id = ?`
-	_, err = database.DB.Exec(updateSQL, newWeeklyMinutes, id)
+//given a userId and a number of minutes, add those minutes to the user's weekly_minutes as a grant from the given admin
+func AddUserWeeklyMinutes(id int, request AddUserWeeklyMinutesRequest, adminId int) error {
+	err := changeMinutes(minuteChange{userId: id, delta: request.Minutes, entryType: LedgerGrant, actorId: adminId, note: "added by an admin"})
 	if err != nil {
 		return fmt.Errorf("error adding minutes to user: %v", err)
 	}
@@ -217,7 +284,7 @@ func SetUserBanTime(id int, request SetUserBanTimeRequest) error {
 		if err != nil {
 			return fmt.Errorf("error setting ban time to NULL for user: %v", err)
 		}
-		return nil
+		return scheduler.Cancel(scheduler.JobClearBan, id)
 	}
 	var currentBanTimeEnd *time.Time
 
@@ -229,7 +296,7 @@ func SetUserBanTime(id int, request SetUserBanTimeRequest) error {
 
 	var newBanTimeEnd time.Time
 
-	if currentBanTimeEnd == nil { //if null, set to current time + requested ban time
+	if currentBanTimeEnd == nil || currentBanTimeEnd.Before(time.Now()) { //if null (or already over), set to current time + requested ban time
 		newBanTimeEnd = time.Now().Add(time.Duration(request.BanTime) * time.Hour)
 	} else { //if not null, set to existing ban time end + requested ban time
 		newBanTimeEnd = currentBanTimeEnd.Add(time.Duration(request.BanTime) * time.Hour)
@@ -241,6 +308,16 @@ func SetUserBanTime(id int, request SetUserBanTimeRequest) error {
 		return fmt.Errorf("error adding ban time to user: %v", err)
 	}
 
+	//clear the ban automatically once it ends
+	if _, err = scheduler.Schedule(scheduler.JobClearBan, id, newBanTimeEnd); err != nil {
+		return fmt.Errorf("error scheduling end of ban: %v", err)
+	}
+
+	//a banned user can't log in, so they shouldn't be able to keep refreshing a session they already have
+	if _, err = util.RevokeUserRefreshTokens(id, util.RevokeAdmin); err != nil {
+		log.Printf("failed to revoke sessions of banned user %d: %v", id, err)
+	}
+
 	return nil
 }
 
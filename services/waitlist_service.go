@@ -0,0 +1,465 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/scheduler"
+	"log"
+	"strings"
+	"time"
+)
+
+// waitlist entry statuses stored in waitlist.status
+const (
+	WaitlistWaiting   = "waiting"   //in line
+	WaitlistOffered   = "offered"   //a printer is being held for the user until offer_expires_at
+	WaitlistClaimed   = "claimed"   //the user reserved the offered printer
+	WaitlistExpired   = "expired"   //the offer ran out before the user reserved the printer
+	WaitlistCancelled = "cancelled" //the user left the waitlist
+)
+
+// how long a freed printer is held for the next person in line
+const waitlistClaimWindow = 10 * time.Minute
+
+// define reusable waitlist errors
+var (
+	ErrorPrinterHeld      = errors.New("printer is being held for the next person on the waitlist")
+	ErrorNotWaitlistOwner = errors.New("waitlist entry belongs to another user")
+)
+
+type JoinWaitlistRequest struct {
+	PrinterId int    `json:"printer_id"` // set to wait for a specific printer
+	Color     string `json:"color"`      // or set to wait for any printer of this color
+}
+
+// given a printer or a color, add the user to the end of the waitlist for it. A user can only be in line once
+// for the same printer or color. If a matching printer is already free it is offered right away.
+func JoinWaitlist(request JoinWaitlistRequest, userId int) (*models.WaitlistEntry, error) {
+	request.Color = strings.TrimSpace(request.Color)
+	if (request.PrinterId == 0) == (request.Color == "") {
+		return nil, fmt.Errorf("either printer_id or color must be given, but not both")
+	}
+
+	var matchingPrinters int
+	if request.PrinterId != 0 {
+		var isExecutive, hasExecutiveAccess bool
+		querySQL := `SELECT p.is_executive, u.has_executive_access FROM printers p, users u WHERE p.id = ? AND u.id = ?`
+		err := database.DB.QueryRow(querySQL, request.PrinterId, userId).Scan(&isExecutive, &hasExecutiveAccess)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("printer with id %d not found", request.PrinterId)
+		} else if err != nil {
+			return nil, fmt.Errorf("error checking printer: %v", err)
+		}
+		quota, err := getUserQuota(database.DB, userId)
+		if err != nil {
+			return nil, err
+		}
+		if !quota.allowsPrinter(printerClass(isExecutive), hasExecutiveAccess) {
+			if isExecutive {
+				return nil, ErrorExecutiveAccessRequired
+			}
+			return nil, ErrorPrinterClassNotAllowed
+		}
+	} else {
+		err := database.DB.QueryRow("SELECT COUNT(*) FROM printers WHERE color = ? COLLATE NOCASE", request.Color).Scan(&matchingPrinters)
+		if err != nil {
+			return nil, fmt.Errorf("error checking printer color: %v", err)
+		}
+		if matchingPrinters == 0 {
+			return nil, fmt.Errorf("no printers with color %s exist", request.Color)
+		}
+	}
+
+	var existing int
+	querySQL := `SELECT COUNT(*) FROM waitlist WHERE user_id = ? AND status IN (?, ?)
+				AND (printer_id = ? OR color = ? COLLATE NOCASE)`
+	err := database.DB.QueryRow(querySQL, userId, WaitlistWaiting, WaitlistOffered, request.PrinterId, request.Color).Scan(&existing)
+	if err != nil {
+		return nil, fmt.Errorf("error checking existing waitlist entries: %v", err)
+	}
+	if existing > 0 {
+		return nil, fmt.Errorf("user is already on the waitlist for this printer")
+	}
+
+	var printerId, color interface{} // stored as NULL when not used
+	if request.PrinterId != 0 {
+		printerId = request.PrinterId
+	} else {
+		color = request.Color
+	}
+	result, err := database.DB.Exec("INSERT INTO waitlist (user_id, printer_id, color, status) VALUES (?, ?, ?, ?)",
+		userId, printerId, color, WaitlistWaiting)
+	if err != nil {
+		return nil, fmt.Errorf("error adding user to waitlist: %v", err)
+	}
+	entryId, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting waitlist entry id: %v", err)
+	}
+
+	// someone may have joined for a printer that is sitting free, hand it over now instead of waiting for a reservation to end
+	offerFreePrinters(request.PrinterId, request.Color)
+
+	return getWaitlistEntry(int(entryId))
+}
+
+// given a waitlist entry id, take the user out of line. An outstanding offer is passed to the next person.
+// Only the entry's user (or staff who can manage reservations) can remove it.
+func LeaveWaitlist(entryId int, requesterId int, canManage bool) (bool, error) {
+	entry, err := getWaitlistEntry(entryId)
+	if err != nil {
+		return false, err
+	}
+	if !canManage && entry.UserId != requesterId {
+		return false, ErrorNotWaitlistOwner
+	}
+	if entry.Status != WaitlistWaiting && entry.Status != WaitlistOffered {
+		return false, fmt.Errorf("waitlist entry is no longer in line")
+	}
+
+	_, err = database.DB.Exec("UPDATE waitlist SET status = ? WHERE id = ? AND status IN (?, ?)",
+		WaitlistCancelled, entryId, WaitlistWaiting, WaitlistOffered)
+	if err != nil {
+		return false, fmt.Errorf("error removing waitlist entry: %v", err)
+	}
+
+	if entry.Status == WaitlistOffered {
+		if err := scheduler.Cancel(scheduler.JobExpireWaitlistOffer, entryId); err != nil {
+			log.Printf("failed to cancel offer expiry of waitlist entry %d: %v", entryId, err)
+		}
+		offerFreedPrinter(entry.Offered_Printer_Id)
+	}
+	return true, nil
+}
+
+// return everyone currently in line or holding an offer, with their position in their queue
+func GetWaitlist() ([]models.WaitlistEntry, error) {
+	return queryWaitlist(0)
+}
+
+// return the user's waitlist entries that are still in line or holding an offer, with their positions
+func GetUserWaitlist(userId int) ([]models.WaitlistEntry, error) {
+	entries, err := queryWaitlist(0)
+	if err != nil {
+		return nil, err
+	}
+	userEntries := []models.WaitlistEntry{}
+	for _, e := range entries {
+		if e.UserId == userId {
+			userEntries = append(userEntries, e)
+		}
+	}
+	return userEntries, nil
+}
+
+// return the entries waiting on a printer, either for it specifically or for its color, in line order
+func GetPrinterWaitlist(printerId int) ([]models.WaitlistEntry, error) {
+	return queryWaitlist(printerId)
+}
+
+// load open waitlist entries (oldest first) and work out each one's position. An entry's position counts the
+// waiting entries ahead of it that compete for the same printer, so someone waiting for printer 3 is behind
+// earlier entries for printer 3 and for printer 3's color. When printerId is non-zero only the entries that
+// printer could go to are returned.
+func queryWaitlist(printerId int) ([]models.WaitlistEntry, error) {
+	printerColors, err := getPrinterColors()
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL := `SELECT w.id, w.user_id, u.username, w.printer_id, w.color, w.status, w.offered_printer_id, w.offer_expires_at, w.created_at
+				FROM waitlist w
+				JOIN users u ON w.user_id = u.id
+				WHERE w.status IN (?, ?)
+				ORDER BY w.id ASC`
+	rows, err := database.DB.Query(querySQL, WaitlistWaiting, WaitlistOffered)
+	if err != nil {
+		return nil, fmt.Errorf("error getting waitlist: %v", err)
+	}
+	defer rows.Close()
+
+	var all []models.WaitlistEntry
+	for rows.Next() {
+		var e models.WaitlistEntry
+		var entryPrinterId, offeredPrinterId sql.NullInt64
+		var color sql.NullString
+		var offerExpiresAt sql.NullTime
+		if err := rows.Scan(&e.Id, &e.UserId, &e.Username, &entryPrinterId, &color, &e.Status, &offeredPrinterId, &offerExpiresAt, &e.Created_At); err != nil {
+			return nil, fmt.Errorf("error scanning waitlist entry: %v", err)
+		}
+		e.PrinterId = int(entryPrinterId.Int64)
+		e.Color = color.String
+		e.Offered_Printer_Id = int(offeredPrinterId.Int64)
+		if offerExpiresAt.Valid {
+			e.Offer_Expires_At = &offerExpiresAt.Time
+		}
+		all = append(all, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+
+	entries := []models.WaitlistEntry{}
+	for i := range all {
+		if all[i].Status == WaitlistWaiting {
+			all[i].Position = 1
+			for j := 0; j < i; j++ {
+				if all[j].Status == WaitlistWaiting && waitlistEntriesCompete(all[i], all[j], printerColors) {
+					all[i].Position++
+				}
+			}
+		}
+		if printerId == 0 || waitlistEntryMatches(all[i], printerId, printerColors[printerId]) {
+			entries = append(entries, all[i])
+		}
+	}
+	return entries, nil
+}
+
+// return whether a freed printer with the given id and color could go to the entry
+func waitlistEntryMatches(entry models.WaitlistEntry, printerId int, color string) bool {
+	if entry.PrinterId != 0 {
+		return entry.PrinterId == printerId
+	}
+	return strings.EqualFold(entry.Color, color)
+}
+
+// return whether two entries could be waiting for the same printer
+func waitlistEntriesCompete(a models.WaitlistEntry, b models.WaitlistEntry, printerColors map[int]string) bool {
+	colorOf := func(e models.WaitlistEntry) string {
+		if e.PrinterId != 0 {
+			return printerColors[e.PrinterId]
+		}
+		return e.Color
+	}
+	if a.PrinterId != 0 && b.PrinterId != 0 {
+		return a.PrinterId == b.PrinterId
+	}
+	return strings.EqualFold(colorOf(a), colorOf(b))
+}
+
+// return a map of printer id to printer color
+func getPrinterColors() (map[int]string, error) {
+	rows, err := database.DB.Query("SELECT id, color FROM printers")
+	if err != nil {
+		return nil, fmt.Errorf("error getting printer colors: %v", err)
+	}
+	defer rows.Close()
+
+	colors := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var color string
+		if err := rows.Scan(&id, &color); err != nil {
+			return nil, fmt.Errorf("error scanning printer color: %v", err)
+		}
+		colors[id] = color
+	}
+	return colors, rows.Err()
+}
+
+// given a waitlist entry id, return that entry with its current position
+func getWaitlistEntry(entryId int) (*models.WaitlistEntry, error) {
+	entries, err := queryWaitlist(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Id == entryId {
+			return &e, nil
+		}
+	}
+
+	// no longer in line, return it without a position
+	var e models.WaitlistEntry
+	var entryPrinterId, offeredPrinterId sql.NullInt64
+	var color sql.NullString
+	querySQL := `SELECT w.id, w.user_id, u.username, w.printer_id, w.color, w.status, w.offered_printer_id, w.created_at
+				FROM waitlist w JOIN users u ON w.user_id = u.id WHERE w.id = ?`
+	err = database.DB.QueryRow(querySQL, entryId).Scan(&e.Id, &e.UserId, &e.Username, &entryPrinterId, &color, &e.Status, &offeredPrinterId, &e.Created_At)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no waitlist entry of ID %d exists", entryId)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting waitlist entry: %v", err)
+	}
+	e.PrinterId = int(entryPrinterId.Int64)
+	e.Color = color.String
+	e.Offered_Printer_Id = int(offeredPrinterId.Int64)
+	return &e, nil
+}
+
+// offer every free printer matching a printer id or color to the waitlist. Used when someone joins the waitlist
+// for a printer that isn't actually busy.
+func offerFreePrinters(printerId int, color string) {
+	rows, err := database.DB.Query("SELECT id FROM printers WHERE in_use = FALSE AND status = ? AND (id = ? OR color = ? COLLATE NOCASE)", PrinterAvailable, printerId, color)
+	if err != nil {
+		log.Printf("failed to find free printers for waitlist: %v", err)
+		return
+	}
+	var freePrinters []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			freePrinters = append(freePrinters, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range freePrinters {
+		offerFreedPrinter(id)
+	}
+}
+
+// runs when a printer frees up. If the printer is free, not already held for someone, and not booked during the
+// claim window, hold it for the first person in line for it (by printer or by color) who could reserve it until the claim
+// window runs out.
+// Returns the entry that got the offer, or 0.
+func offerFreedPrinter(printerId int) int {
+	var inUse bool
+	var status string
+	err := database.DB.QueryRow("SELECT in_use, status FROM printers WHERE id = ?", printerId).Scan(&inUse, &status)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("failed to get printer %d for waitlist offer: %v", printerId, err)
+		}
+		return 0
+	}
+	if inUse || status != PrinterAvailable {
+		return 0
+	}
+	if holder, err := getPrinterOfferHolder(printerId); err != nil || holder != 0 {
+		return 0
+	}
+	// no point holding a printer that someone has booked for the next few minutes
+	if err := checkPrinterAvailability(database.DB, printerId, time.Now(), time.Now().Add(waitlistClaimWindow), 0); err != nil {
+		return 0
+	}
+
+	var isExecutive bool
+	if err := database.DB.QueryRow("SELECT is_executive FROM printers WHERE id = ?", printerId).Scan(&isExecutive); err != nil {
+		log.Printf("failed to get class of printer %d for waitlist offer: %v", printerId, err)
+		return 0
+	}
+
+	querySQL := `SELECT w.id, w.user_id FROM waitlist w
+				JOIN printers p ON p.id = ?
+				WHERE w.status = ? AND (w.printer_id = p.id OR w.color = p.color COLLATE NOCASE)
+				ORDER BY w.id ASC`
+	rows, err := database.DB.Query(querySQL, printerId, WaitlistWaiting)
+	if err != nil {
+		log.Printf("failed to get next waitlist entry for printer %d: %v", printerId, err)
+		return 0
+	}
+	var waiting []models.WaitlistEntry
+	for rows.Next() {
+		var e models.WaitlistEntry
+		if err := rows.Scan(&e.Id, &e.UserId); err != nil {
+			rows.Close()
+			log.Printf("failed to scan waitlist entry: %v", err)
+			return 0
+		}
+		waiting = append(waiting, e)
+	}
+	rows.Close()
+
+	// skip anyone who couldn't reserve the printer anyway (banned, or their quota tier doesn't allow it), an
+	// offer they can't claim would only hold the printer until it expires
+	expiresAt := time.Now().Add(waitlistClaimWindow)
+	entryId, userId := 0, 0
+	for _, e := range waiting {
+		classes, err := reservablePrinterClasses(e.UserId)
+		if err != nil {
+			log.Printf("failed to check whether user %d can reserve printer %d: %v", e.UserId, printerId, err)
+			continue
+		}
+		if !classes[printerClass(isExecutive)] {
+			continue
+		}
+
+		result, err := database.DB.Exec("UPDATE waitlist SET status = ?, offered_printer_id = ?, offer_expires_at = ? WHERE id = ? AND status = ?",
+			WaitlistOffered, printerId, expiresAt, e.Id, WaitlistWaiting)
+		if err != nil {
+			log.Printf("failed to offer printer %d to waitlist entry %d: %v", printerId, e.Id, err)
+			return 0
+		}
+		if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 1 {
+			entryId, userId = e.Id, e.UserId
+			break
+		}
+	}
+	if entryId == 0 { //nobody waiting who can take it
+		return 0
+	}
+
+	if _, err := scheduler.Schedule(scheduler.JobExpireWaitlistOffer, entryId, expiresAt); err != nil {
+		log.Printf("failed to schedule expiry of waitlist offer %d: %v", entryId, err)
+	}
+	log.Printf("Printer %d offered to user %d (waitlist entry %d) until %s", printerId, userId, entryId, expiresAt.Format(time.RFC3339))
+	return entryId
+}
+
+// given a printer id, return the user an unexpired waitlist offer is holding it for, or 0 if it isn't held
+func getPrinterOfferHolder(printerId int) (int, error) {
+	rows, err := database.DB.Query("SELECT user_id, offer_expires_at FROM waitlist WHERE status = ? AND offered_printer_id = ?", WaitlistOffered, printerId)
+	if err != nil {
+		return 0, fmt.Errorf("error checking waitlist offers: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId int
+		var expiresAt time.Time
+		if err := rows.Scan(&userId, &expiresAt); err != nil {
+			return 0, fmt.Errorf("error scanning waitlist offer: %v", err)
+		}
+		if expiresAt.After(time.Now()) {
+			return userId, nil
+		}
+	}
+	return 0, rows.Err()
+}
+
+// given a user and a printer they just reserved, mark their offer for that printer as claimed
+func claimWaitlistOffer(userId int, printerId int) {
+	var entryId int
+	err := database.DB.QueryRow("SELECT id FROM waitlist WHERE status = ? AND user_id = ? AND offered_printer_id = ?",
+		WaitlistOffered, userId, printerId).Scan(&entryId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("failed to look up waitlist offer of user %d for printer %d: %v", userId, printerId, err)
+		}
+		return
+	}
+
+	if _, err := database.DB.Exec("UPDATE waitlist SET status = ? WHERE id = ?", WaitlistClaimed, entryId); err != nil {
+		log.Printf("failed to mark waitlist entry %d claimed: %v", entryId, err)
+		return
+	}
+	if err := scheduler.Cancel(scheduler.JobExpireWaitlistOffer, entryId); err != nil {
+		log.Printf("failed to cancel offer expiry of waitlist entry %d: %v", entryId, err)
+	}
+}
+
+// expires a waitlist offer that wasn't claimed in time and passes the printer to the next person in line
+func expireWaitlistOfferJob(job models.Job) error {
+	var printerId int
+	var status string
+	err := database.DB.QueryRow("SELECT offered_printer_id, status FROM waitlist WHERE id = ?", job.ReferenceId).Scan(&printerId, &status)
+	if err == sql.ErrNoRows || (err == nil && status != WaitlistOffered) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error getting waitlist entry %d: %v", job.ReferenceId, err)
+	}
+
+	_, err = database.DB.Exec("UPDATE waitlist SET status = ? WHERE id = ? AND status = ?", WaitlistExpired, job.ReferenceId, WaitlistOffered)
+	if err != nil {
+		return fmt.Errorf("error expiring waitlist entry %d: %v", job.ReferenceId, err)
+	}
+	log.Printf("Waitlist offer %d for printer %d expired", job.ReferenceId, printerId)
+
+	offerFreedPrinter(printerId)
+	return nil
+}
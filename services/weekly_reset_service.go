@@ -0,0 +1,302 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"gin-api/database"
+	"gin-api/models"
+	"gin-api/scheduler"
+	"gin-api/util"
+	"log"
+	"time"
+)
+
+// how a weekly reset run was started, stored in weekly_reset_runs.triggered_by
+const (
+	ResetTriggerScheduled = "scheduled" // ran at (or close to) its reset time
+	ResetTriggerCatchUp   = "catch_up"  // ran late because the API was down at the reset time
+)
+
+// a reset job that runs more than this long after its reset time is recorded as a catch-up
+const resetCatchUpGrace = time.Hour
+
+// get the reset settings from the global obj if it is up to date.
+// If it is not up to date, import the settings from the DB and then get them.
+func GetResetSettings() (models.ResetSettings, error) {
+	var err error = nil //no error by default
+	if !util.Settings.ResetSettings.UpToDate {
+		err = util.ImportSettingsFromDB()
+	}
+	return util.Settings.ResetSettings, err
+}
+
+// sets when the weekly reset runs and how many unused minutes roll over, then moves the pending reset job
+// to the new reset time. A reset that was due under the old schedule isn't run early or made up.
+func SetResetSettings(request models.ResetSettings) error {
+	if request.ResetWeekday < 0 || request.ResetWeekday > 6 {
+		return fmt.Errorf("reset_weekday must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	if _, err := parseClockTime(request.ResetTime); err != nil {
+		return fmt.Errorf("invalid reset_time: %v", err)
+	}
+	if request.RolloverCapMinutes < 0 {
+		return fmt.Errorf("rollover cap can't be negative")
+	}
+
+	//update global obj
+	util.Settings.ResetSettings.ResetWeekday = request.ResetWeekday
+	util.Settings.ResetSettings.ResetTime = request.ResetTime
+	util.Settings.ResetSettings.RolloverCapMinutes = request.RolloverCapMinutes
+	util.Settings.ResetSettings.UpToDate = true
+
+	//update in database
+	updateSQL := `UPDATE settings SET reset_weekday = ?, reset_time = ?, rollover_cap_minutes = ? WHERE name = "default"`
+	if _, err := database.DB.Exec(updateSQL, request.ResetWeekday, request.ResetTime, request.RolloverCapMinutes); err != nil {
+		return fmt.Errorf("error updating settings in db: %v", err)
+	}
+	return rescheduleWeeklyReset()
+}
+
+// move the pending weekly_reset job to the next reset time under the current settings. Used when the reset day,
+// time or the lab's timezone changes, the job would otherwise still run at the time it was scheduled for.
+func rescheduleWeeklyReset() error {
+	next, err := nextWeeklyReset(time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := scheduler.Schedule(scheduler.JobWeeklyReset, 0, next); err != nil {
+		return fmt.Errorf("error rescheduling weekly reset: %v", err)
+	}
+	log.Printf("Next weekly reset is at %s", next.Format(time.RFC3339))
+	return nil
+}
+
+// runs on startup (in main.go). If no reset job is pending (a new database, or one the cron script used to
+// reset) and the most recent reset time passed without a reset, the reset is caught up now. Then makes sure
+// a weekly_reset job is pending, the job reschedules itself after every run. Overdue jobs that are already
+// pending are caught up by the scheduler.
+func ScheduleWeeklyReset() error {
+	pending, err := scheduler.HasPending(scheduler.JobWeeklyReset, 0)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return nil
+	}
+
+	now := time.Now()
+	due, err := lastWeeklyReset(now)
+	if err != nil {
+		return err
+	}
+	if _, err := ResetWeeklyMinutes(due, ResetTriggerCatchUp); err != nil {
+		log.Printf("failed to catch up weekly reset: %v", err)
+	}
+
+	next, err := nextWeeklyReset(now)
+	if err != nil {
+		return err
+	}
+	_, err = scheduler.Schedule(scheduler.JobWeeklyReset, 0, next)
+	return err
+}
+
+// return the most recent reset time at or before the given time, in lab time
+func lastWeeklyReset(before time.Time) (time.Time, error) {
+	settings, err := GetResetSettings()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting reset settings: %v", err)
+	}
+	resetMinutes, err := parseClockTime(settings.ResetTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid reset_time setting: %v", err)
+	}
+
+	local := before.In(util.LabLocation())
+	daysSince := (int(local.Weekday()) - settings.ResetWeekday + 7) % 7
+	last := time.Date(local.Year(), local.Month(), local.Day()-daysSince, resetMinutes/60, resetMinutes%60, 0, 0, local.Location())
+	if last.After(before) {
+		last = last.AddDate(0, 0, -7)
+	}
+	return last, nil
+}
+
+// return the next reset time after the given time, in lab time
+func nextWeeklyReset(after time.Time) (time.Time, error) {
+	last, err := lastWeeklyReset(after)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return last.AddDate(0, 0, 7), nil
+}
+
+// resets everyone's weekly minutes for the reset time the job was scheduled at, then queues the following week's reset
+func weeklyResetJob(job models.Job) error {
+	trigger := ResetTriggerScheduled
+	if time.Since(job.RunAt) > resetCatchUpGrace {
+		trigger = ResetTriggerCatchUp
+	}
+	if _, err := ResetWeeklyMinutes(job.RunAt, trigger); err != nil {
+		return err
+	}
+
+	next, err := nextWeeklyReset(time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = scheduler.Schedule(scheduler.JobWeeklyReset, 0, next)
+	return err
+}
+
+// given the reset time a run is for and what triggered it, set every user's weekly_minutes to the default weekly
+// hours plus their unused minutes up to the rollover cap, and record the run. Returns whether a reset happened,
+// there is nothing to do if a reset already ran for that reset time or a later one.
+func ResetWeeklyMinutes(periodStart time.Time, trigger string) (bool, error) {
+	timeSettings, err := GetTimeSettings()
+	if err != nil {
+		return false, fmt.Errorf("error getting time settings: %v", err)
+	}
+	resetSettings, err := GetResetSettings()
+	if err != nil {
+		return false, fmt.Errorf("error getting reset settings: %v", err)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// checked within the transaction so a catch-up and the job can't both reset the same week
+	lastReset, txErr := lastResetTime(tx)
+	if txErr != nil {
+		return false, txErr
+	}
+	if !lastReset.Before(periodStart) {
+		tx.Rollback() //nothing to reset
+		log.Printf("Weekly minutes were already reset for %s, skipping", periodStart.In(util.LabLocation()).Format("Mon Jan 2 3:04 PM"))
+		return false, nil
+	}
+
+	// users on a quota tier get the tier's weekly hours, everyone else the default
+	weeklyMinutes := timeSettings.DefaultUserWeeklyHours * 60
+	baseMinutes := "COALESCE((SELECT weekly_hours * 60 FROM quota_tiers WHERE id = users.quota_tier_id), ?)"
+	rolloverCap := resetSettings.RolloverCapMinutes
+	// each user keeps their unused minutes, up to the cap. Overdrawn balances don't carry their debt over.
+	rolledOver := "MIN(MAX(weekly_minutes, 0), ?)"
+
+	var usersReset, minutesRolledOver int
+	txErr = tx.QueryRow("SELECT COUNT(*), COALESCE(SUM("+rolledOver+"), 0) FROM users", rolloverCap).Scan(&usersReset, &minutesRolledOver)
+	if txErr != nil {
+		return false, fmt.Errorf("error counting unused minutes: %v", txErr)
+	}
+
+	_, txErr = tx.Exec(`INSERT INTO minute_ledger (user_id, delta, balance_after, entry_type, note)
+						SELECT id, `+baseMinutes+` + `+rolledOver+` - weekly_minutes, `+baseMinutes+` + `+rolledOver+`, ?, 'weekly reset, ' || `+rolledOver+` || ' minutes rolled over'
+						FROM users`, weeklyMinutes, rolloverCap, weeklyMinutes, rolloverCap, LedgerWeeklyReset, rolloverCap)
+	if txErr != nil {
+		return false, fmt.Errorf("error recording weekly reset in the minute ledger: %v", txErr)
+	}
+	if _, txErr = tx.Exec("UPDATE users SET weekly_minutes = "+baseMinutes+" + "+rolledOver, weeklyMinutes, rolloverCap); txErr != nil {
+		return false, fmt.Errorf("error resetting weekly minutes: %v", txErr)
+	}
+
+	now := time.Now()
+	_, txErr = tx.Exec(`INSERT INTO weekly_reset_runs (period_start, ran_at, triggered_by, weekly_minutes, rollover_cap_minutes, users_reset, minutes_rolled_over)
+						VALUES (?, ?, ?, ?, ?, ?, ?)`, periodStart, now, trigger, weeklyMinutes, rolloverCap, usersReset, minutesRolledOver)
+	if txErr != nil {
+		return false, fmt.Errorf("error recording weekly reset run: %v", txErr)
+	}
+	// kept up to date for anything still reading the date the old cron script wrote
+	if _, txErr = tx.Exec(`UPDATE settings SET last_ran_date = ? WHERE name = "default"`, now.In(util.LabLocation()).Format("2006-01-02")); txErr != nil {
+		return false, fmt.Errorf("error updating last weekly reset date: %v", txErr)
+	}
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit weekly reset: %v", err)
+	}
+
+	log.Printf("Weekly minutes reset to %d (or their tier's hours) for %d users (%s), %d unused minutes rolled over", weeklyMinutes, usersReset, trigger, minutesRolledOver)
+	return true, nil
+}
+
+// return the reset time of the most recent weekly reset run. Databases the cron script reset before the API kept
+// runs fall back to the script's last_ran_date, counted as the end of that day.
+func lastResetTime(db dbExecutor) (time.Time, error) {
+	var periodStart time.Time
+	err := db.QueryRow("SELECT period_start FROM weekly_reset_runs ORDER BY id DESC LIMIT 1").Scan(&periodStart)
+	if err == nil {
+		return periodStart, nil
+	} else if err != sql.ErrNoRows {
+		return time.Time{}, fmt.Errorf("error getting last weekly reset: %v", err)
+	}
+
+	var lastRanDate time.Time
+	if err := db.QueryRow(`SELECT last_ran_date FROM settings WHERE name = "default"`).Scan(&lastRanDate); err != nil {
+		return time.Time{}, fmt.Errorf("error getting last weekly reset date: %v", err)
+	}
+	return time.Date(lastRanDate.Year(), lastRanDate.Month(), lastRanDate.Day(), 23, 59, 59, 0, util.LabLocation()), nil
+}
+
+// returns every weekly reset run, newest first
+func GetWeeklyResetRuns() ([]models.WeeklyResetRun, error) {
+	rows, err := database.DB.Query(`SELECT id, period_start, ran_at, triggered_by, weekly_minutes, rollover_cap_minutes, users_reset, minutes_rolled_over
+									FROM weekly_reset_runs ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("error getting weekly reset runs: %v", err)
+	}
+	defer rows.Close()
+
+	runs := []models.WeeklyResetRun{}
+	for rows.Next() {
+		var r models.WeeklyResetRun
+		if err := rows.Scan(&r.Id, &r.Period_Start, &r.Ran_At, &r.Triggered_By, &r.Weekly_Minutes, &r.Rollover_Cap_Minutes,
+			&r.Users_Reset, &r.Minutes_Rolled_Over); err != nil {
+			return nil, fmt.Errorf("error scanning weekly reset run: %v", err)
+		}
+		runs = append(runs, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return runs, nil
+}
+
+// returns the reset settings, the last reset run and when the next reset is scheduled
+func GetWeeklyResetStatus() (*models.WeeklyResetStatus, error) {
+	settings, err := GetResetSettings()
+	if err != nil {
+		return nil, fmt.Errorf("error getting reset settings: %v", err)
+	}
+	status := models.WeeklyResetStatus{Settings: settings}
+
+	runs, err := GetWeeklyResetRuns()
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) > 0 {
+		status.Last_Run = &runs[0]
+	}
+
+	// the pending job is what will actually run, fall back to the schedule if there isn't one
+	jobs, err := scheduler.GetPendingJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.Type == scheduler.JobWeeklyReset {
+			status.Next_Reset = job.RunAt
+			break
+		}
+	}
+	if status.Next_Reset.IsZero() {
+		if status.Next_Reset, err = nextWeeklyReset(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return &status, nil
+}
@@ -5,18 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"gin-api/database"
-	"log"
-	"os"
+	"gin-api/models"
 	"time"
 
 	"github.com/golang-jwt/jwt"
-	"github.com/joho/godotenv"
 )
 
 // define reusable token errors
 var (
 	ErrExpiredToken = errors.New("token has expired")
 	ErrInvalidToken = errors.New("invalid token")
+	ErrRevokedToken = errors.New("token has been revoked")
+	ErrTokenReused  = errors.New("refresh token was already used")
 )
 
 type TokenPair struct {
@@ -24,34 +24,80 @@ type TokenPair struct {
 	RefreshToken string
 }
 
-// loads the local secret key from .env which is required to sign tokens and use API in general
-func loadSecretKey() []byte {
-	err := godotenv.Load(".env")
+// given a userId, return the name of the user's role and the permissions it grants. The admin role always
+// has every permission, users without a role are treated as the user role.
+func GetUserRole(userId int) (string, []string, error) {
+	var roleId sql.NullInt64
+	var role string
+	querySQL := `SELECT r.id, COALESCE(r.name, ?) FROM users u LEFT JOIN roles r ON r.id = u.role_id WHERE u.id = ?`
+	err := database.DB.QueryRow(querySQL, models.RoleUser, userId).Scan(&roleId, &role)
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		return "", nil, err
 	}
-	secretKey := os.Getenv("JWT_SECRET_KEY")
-	return []byte(secretKey)
+	if role == models.RoleAdmin {
+		return role, models.AllPermissions, nil
+	}
+
+	permissions := []string{}
+	if !roleId.Valid {
+		return role, permissions, nil
+	}
+	rows, err := database.DB.Query("SELECT permission FROM role_permissions WHERE role_id = ? ORDER BY permission", roleId.Int64)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting permissions of role %s: %v", role, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return "", nil, fmt.Errorf("error scanning permission: %v", err)
+		}
+		permissions = append(permissions, permission)
+	}
+	return role, permissions, rows.Err()
 }
 
-// create a token pair based on the user id and admin status
-func GenerateTokenPair(userId int, isAdmin bool) (*TokenPair, error) {
+// create a token pair based on the user id and the user's role. The access token carries the role's
+// permissions, so changes to them apply once the access token is refreshed. The refresh token starts a new
+// session (a family of refresh tokens that replace each other as they are used).
+func GenerateTokenPair(userId int, role string, permissions []string) (*TokenPair, error) {
+	familyId, err := newTokenId()
+	if err != nil {
+		return nil, err
+	}
+	return generateTokenPair(userId, role, permissions, familyId)
+}
+
+// given a user, their role and the session the tokens belong to, sign a new access token and issue a new
+// refresh token in that session
+func generateTokenPair(userId int, role string, permissions []string, familyId string) (*TokenPair, error) {
 	accessToken := jwt.New(jwt.SigningMethodHS256)
 	accessClaims := accessToken.Claims.(jwt.MapClaims)
 	accessClaims["userId"] = userId
-	accessClaims["isAdmin"] = isAdmin
+	accessClaims["role"] = role
+	accessClaims["permissions"] = permissions
 	accessClaims["exp"] = time.Now().Add(15 * time.Minute).Unix() //expires after 15 minutes
 	accessClaims["type"] = "access"
 
+	tokenId, err := newTokenId()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(refreshTokenLifetime)
 	refreshToken := jwt.New(jwt.SigningMethodHS256)
 	refreshClaims := refreshToken.Claims.(jwt.MapClaims)
 	refreshClaims["userId"] = userId
-	refreshClaims["isAdmin"] = isAdmin
-	refreshClaims["exp"] = time.Now().Add(7 * 24 * time.Hour).Unix() //expires after one week
+	refreshClaims["jti"] = tokenId
+	refreshClaims["exp"] = expiresAt.Unix() //expires after one week
 	refreshClaims["type"] = "refresh"
 
-	//get jwt Secret Key from .env
-	jwtSecret := loadSecretKey()
+	//sign both tokens with the active key, stamping its id so they can be verified after the key is rotated
+	kid, jwtSecret, err := signingKeyForNewTokens()
+	if err != nil {
+		return nil, err
+	}
+	accessToken.Header["kid"] = kid
+	refreshToken.Header["kid"] = kid
 
 	//sign access token
 	accessTokenString, err := accessToken.SignedString(jwtSecret)
@@ -65,6 +111,11 @@ func GenerateTokenPair(userId int, isAdmin bool) (*TokenPair, error) {
 		return nil, err
 	}
 
+	//refresh tokens are only accepted while their record says they are unused and not revoked
+	if err := storeRefreshToken(tokenId, familyId, userId, expiresAt); err != nil {
+		return nil, err
+	}
+
 	return &TokenPair{
 		AccessToken:  accessTokenString,
 		RefreshToken: refreshTokenString,
@@ -73,8 +124,12 @@ func GenerateTokenPair(userId int, isAdmin bool) (*TokenPair, error) {
 
 func ValidateToken(tokenString string) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		jwtSecret := loadSecretKey()
-		return jwtSecret, nil
+		//only HMAC signed tokens are issued, anything else is forged
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidToken
+		}
+		kid, _ := token.Header["kid"].(string)
+		return verificationKey(kid)
 	})
 
 	if err != nil {
@@ -89,45 +144,56 @@ func ValidateToken(tokenString string) (*jwt.Token, error) {
 	return token, nil
 }
 
-func RefreshAccessToken(refreshToken string) (string, error) {
+// given a refresh token, return a new token pair for the same session. The refresh token is used up, so
+// presenting it again is treated as theft: the whole session is revoked and ErrTokenReused is returned.
+func RefreshAccessToken(refreshToken string) (*TokenPair, error) {
 	// Validate refresh token
-	token, err := ValidateToken(refreshToken)
+	claims, err := parseRefreshToken(refreshToken)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	userId := int(claims["userId"].(float64))
+	tokenId, _ := claims["jti"].(string)
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", ErrInvalidToken
+	// mark the token used before anything is issued, so two requests racing with the same token can't both succeed
+	familyId, err := useRefreshToken(tokenId)
+	if err != nil {
+		return nil, err
 	}
 
-	// Verify it's a refresh token
-	if claims["type"] != "refresh" {
-		return "", ErrInvalidToken
+	// Query the database to get the user's current role and permissions
+	role, permissions, err := GetUserRole(userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("user no longer exists")
+		}
+		return nil, fmt.Errorf("database error: %v", err)
 	}
 
-	// Generate new access token
-	userId := int(claims["userId"].(float64))
-	// Extract userId from claims
+	return generateTokenPair(userId, role, permissions, familyId)
+}
 
-	// Query the database to get latest user data
-	var isAdmin bool
-	err = database.DB.QueryRow("SELECT admin FROM users WHERE id = ?", userId).Scan(
-		&isAdmin)
+// given a refresh token, check its signature and that it is a refresh token, then return its claims
+func parseRefreshToken(refreshToken string) (jwt.MapClaims, error) {
+	token, err := ValidateToken(refreshToken)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", errors.New("user no longer exists")
-		}
-		return "", fmt.Errorf("database error: %v", err)
+		return nil, err
 	}
 
-	newToken := jwt.New(jwt.SigningMethodHS256)
-	newClaims := newToken.Claims.(jwt.MapClaims)
-	newClaims["userId"] = userId
-	newClaims["isAdmin"] = isAdmin
-	newClaims["exp"] = time.Now().Add(15 * time.Minute).Unix()
-	newClaims["type"] = "access"
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
 
-	jwtSecret := loadSecretKey()
-	return newToken.SignedString(jwtSecret)
+	// Verify it's a refresh token from a session the API keeps a record of
+	if claims["type"] != "refresh" {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := claims["userId"].(float64); !ok {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := claims["jti"].(string); !ok {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
 }
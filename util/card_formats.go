@@ -0,0 +1,193 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// names of the built-in card formats, used in the card_formats setting
+const (
+	CardFormatTrack1  = "track1"  // magstripe Track 1: %B<id>^LAST/FIRST^...
+	CardFormatTrack2  = "track2"  // magstripe Track 2: ;<id>=...?
+	CardFormatBarcode = "barcode" // the card number printed as a barcode
+	CardFormatNfcUid  = "nfc_uid" // the UID of an NFC tag, in hex
+)
+
+// kinds of credential key. Formats that read the same thing share a key type, so a card enrolled by swiping it
+// also logs in when its barcode is scanned.
+const (
+	CardKeyNumber = "card_number"
+	CardKeyNfcUid = "nfc_uid"
+)
+
+// a kind of scan a card reader can send. Detect is a cheap check of whether a scan looks like the format,
+// Parse reads the scan once it has been detected and returns an error if it is malformed. KeyType is the kind
+// of credential key the format reads.
+type CardFormat struct {
+	Name    string
+	KeyType string
+	Detect  func(scannerString string) bool
+	Parse   func(scannerString string) (*ParsedCardData, error)
+}
+
+// every card format the API knows, by name, and the order they are tried in when the setting doesn't say
+var (
+	cardFormats     = map[string]CardFormat{}
+	cardFormatOrder = []string{}
+)
+
+// given a card format, make it available to the card_formats setting. Registering a name again replaces it.
+func RegisterCardFormat(format CardFormat) {
+	if _, exists := cardFormats[format.Name]; !exists {
+		cardFormatOrder = append(cardFormatOrder, format.Name)
+	}
+	cardFormats[format.Name] = format
+}
+
+// returns the names of every registered card format
+func CardFormatNames() []string {
+	return append([]string{}, cardFormatOrder...)
+}
+
+// given a name, return whether a card format is registered under it
+func IsCardFormat(name string) bool {
+	_, ok := cardFormats[name]
+	return ok
+}
+
+// returns the card formats scans are read as, in the order they are tried
+func enabledCardFormats() []string {
+	if len(Settings.CardSettings.Formats) == 0 {
+		return cardFormatOrder
+	}
+	return Settings.CardSettings.Formats
+}
+
+// given the card_formats column, return the format names in it
+func SplitCardFormats(formats string) []string {
+	list := []string{}
+	for _, name := range strings.Split(formats, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// registers the built-in card formats. Tried in this order unless the card_formats setting says otherwise.
+// Barcodes and NFC UIDs are only detected at lengths they can have, but a scan of 8 or 14 digits can be either
+// one. It is read as whichever of barcode and nfc_uid is tried first, so a lab whose tags have UIDs made of only
+// digits lists nfc_uid before barcode.
+func init() {
+	RegisterCardFormat(CardFormat{
+		Name:    CardFormatTrack1,
+		KeyType: CardKeyNumber,
+		Detect:  func(s string) bool { return strings.HasPrefix(s, "%B") },
+		Parse:   parseTrack1,
+	})
+	RegisterCardFormat(CardFormat{
+		Name:    CardFormatTrack2,
+		KeyType: CardKeyNumber,
+		Detect:  func(s string) bool { return strings.HasPrefix(s, ";") },
+		Parse:   parseTrack2,
+	})
+	RegisterCardFormat(CardFormat{
+		Name:    CardFormatBarcode,
+		KeyType: CardKeyNumber,
+		Detect:  func(s string) bool { return isDigits(s) && isCardNumberLength(len(s)) },
+		Parse:   parseBarcode,
+	})
+	RegisterCardFormat(CardFormat{
+		Name:    CardFormatNfcUid,
+		KeyType: CardKeyNfcUid,
+		Detect:  looksLikeNfcUid,
+		Parse:   parseNfcUid,
+	})
+}
+
+// parse a magstripe Track 2 string: ;<id>=<expiry and discretionary data>?
+func parseTrack2(scannerString string) (*ParsedCardData, error) {
+	data := strings.TrimPrefix(scannerString, ";")
+	end := strings.Index(data, "?")
+	if end == -1 {
+		return nil, fmt.Errorf("missing end sentinel")
+	}
+	data = data[:end]
+	id, _, _ := strings.Cut(data, "=")
+	return cardNumber(id)
+}
+
+// parse a barcode of the card number
+func parseBarcode(scannerString string) (*ParsedCardData, error) {
+	return cardNumber(scannerString)
+}
+
+// given a card number read from a card, check it and return it as the key. Leading zeros are dropped so every
+// format reads the same number for a card.
+func cardNumber(number string) (*ParsedCardData, error) {
+	if !isCardNumberLength(len(number)) || !isDigits(number) {
+		return nil, fmt.Errorf("card number must be 4 to 19 digits")
+	}
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse card number: %v", err)
+	}
+	return &ParsedCardData{Key: strconv.FormatUint(n, 10)}, nil
+}
+
+// parse an NFC tag UID: 4, 7 or 10 bytes in hex, optionally separated by colons, dashes or spaces
+func parseNfcUid(scannerString string) (*ParsedCardData, error) {
+	uid := normalizeUid(scannerString)
+	if !isUidLength(len(uid)) {
+		return nil, fmt.Errorf("UID must be 4, 7 or 10 bytes, got %d hex digits", len(uid))
+	}
+	return &ParsedCardData{Key: uid}, nil
+}
+
+// return whether a card number can have this many digits
+func isCardNumberLength(digits int) bool {
+	return digits >= 4 && digits <= 19
+}
+
+// return whether an NFC UID can have this many hex digits (4, 7 or 10 bytes)
+func isUidLength(digits int) bool {
+	return digits == 8 || digits == 14 || digits == 20
+}
+
+// return whether the scan is hex of a length an NFC UID can have, once separators are removed
+func looksLikeNfcUid(scannerString string) bool {
+	uid := normalizeUid(scannerString)
+	return isHex(uid) && isUidLength(len(uid))
+}
+
+// given a UID as a reader sent it, return it in upper case hex with the separators removed
+func normalizeUid(uid string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "", " ", "").Replace(uid))
+}
+
+// return whether the string is only the digits 0-9
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// return whether the string is only hex digits
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'A' || r > 'F') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
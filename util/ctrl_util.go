@@ -20,3 +20,34 @@ func GetInfoFromPath(c *gin.Context, requestedInfo string) int {
 	}
 	return info
 }
+
+// return the id of the user making the request, as set by AuthMiddleware from the token's userId claim.
+// JSON numbers in the claims are decoded as float64, so c.GetInt can't be used directly.
+func GetUserIdFromContext(c *gin.Context) (int, bool) {
+	value, exists := c.Get("userId")
+	if !exists {
+		return 0, false
+	}
+	switch id := value.(type) {
+	case float64:
+		return int(id), true
+	case int:
+		return id, true
+	}
+	return 0, false
+}
+
+// return whether the user making the request has the given permission, as set by AuthMiddleware from the
+// token's permissions claim
+func HasPermission(c *gin.Context, permission string) bool {
+	permissions, ok := c.Get("permissions")
+	if !ok {
+		return false
+	}
+	for _, p := range permissions.([]string) {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// where generated signing keys are kept when JWT_SECRET_KEY isn't set. Can be moved with JWT_KEY_FILE.
+const defaultKeyFile = "./jwt_keys.json"
+
+// a retired key keeps verifying tokens until every refresh token signed with it has expired
+const retiredKeyLifetime = refreshTokenLifetime
+
+// define reusable key errors
+var (
+	ErrUnknownKey  = errors.New("token was signed with an unknown key")
+	ErrKeysFromEnv = errors.New("signing keys come from JWT_SECRET_KEY, rotate them in the environment")
+)
+
+// one HS256 secret and the id stamped in the kid header of tokens it signs
+type signingKey struct {
+	Id         string     `json:"kid"`
+	Secret     string     `json:"secret"` // hex encoded
+	Created_At time.Time  `json:"created_at"`
+	Retired_At *time.Time `json:"retired_at,omitempty"` // set once another key replaced it for signing
+}
+
+// the contents of the key file
+type keyFile struct {
+	Active string       `json:"active"`
+	Keys   []signingKey `json:"keys"`
+}
+
+// holds the key tokens are signed with and every key tokens are still verified with
+type keyManager struct {
+	mu       sync.RWMutex
+	loaded   bool
+	fromEnv  bool
+	path     string
+	activeId string
+	keys     map[string][]byte
+	file     keyFile
+}
+
+var keys = &keyManager{}
+
+// runs on startup (in main.go). Loads the signing keys once. JWT_SECRET_KEY (from the environment or .env) is
+// used if it is set, along with any old secrets in JWT_PREVIOUS_SECRET_KEYS ("kid:secret,kid:secret") that
+// tokens are still verified with. Otherwise keys come from the key file, which is created with a new random
+// secret on first boot.
+func LoadSigningKeys() error {
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	return keys.load()
+}
+
+// load the keys from the environment or the key file. The caller holds the lock.
+func (m *keyManager) load() error {
+	if err := godotenv.Load(".env"); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error loading .env file: %v", err)
+	}
+
+	m.keys = map[string][]byte{}
+	if secret := os.Getenv("JWT_SECRET_KEY"); secret != "" {
+		m.fromEnv = true
+		m.activeId = os.Getenv("JWT_SECRET_KEY_ID")
+		if m.activeId == "" {
+			m.activeId = envKeyId(secret)
+		}
+		m.keys[m.activeId] = []byte(secret)
+		for _, previous := range strings.Split(os.Getenv("JWT_PREVIOUS_SECRET_KEYS"), ",") {
+			if previous = strings.TrimSpace(previous); previous == "" {
+				continue
+			}
+			kid, secret, found := strings.Cut(previous, ":")
+			if !found || kid == "" || secret == "" {
+				return fmt.Errorf("JWT_PREVIOUS_SECRET_KEYS entries must look like kid:secret")
+			}
+			m.keys[kid] = []byte(secret)
+		}
+		m.loaded = true
+		log.Printf("Loaded JWT signing key %s from the environment, %d previous key(s)", m.activeId, len(m.keys)-1)
+		return nil
+	}
+
+	m.fromEnv = false
+	m.path = os.Getenv("JWT_KEY_FILE")
+	if m.path == "" {
+		m.path = defaultKeyFile
+	}
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		log.Printf("No JWT signing key found, generating one in %s", m.path)
+		m.file = keyFile{}
+		if err := m.addKey(); err != nil {
+			return err
+		}
+		m.loaded = true
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading key file: %v", err)
+	}
+	if err := json.Unmarshal(data, &m.file); err != nil {
+		return fmt.Errorf("error parsing key file %s: %v", m.path, err)
+	}
+	if err := m.useFile(); err != nil {
+		return err
+	}
+	m.loaded = true
+	log.Printf("Loaded JWT signing key %s from %s, %d previous key(s)", m.activeId, m.path, len(m.keys)-1)
+	return nil
+}
+
+// given the secret from JWT_SECRET_KEY, return a kid for it that doesn't reveal the secret
+func envKeyId(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "env-" + hex.EncodeToString(sum[:4])
+}
+
+// set the active key and verification keys from the key file, dropping retired keys that nothing valid
+// could still be signed with
+func (m *keyManager) useFile() error {
+	m.keys = map[string][]byte{}
+	kept := []signingKey{}
+	for _, key := range m.file.Keys {
+		if key.Id != m.file.Active && key.Retired_At != nil && time.Since(*key.Retired_At) > retiredKeyLifetime {
+			continue
+		}
+		secret, err := hex.DecodeString(key.Secret)
+		if err != nil || len(secret) == 0 {
+			return fmt.Errorf("key %s in %s has an invalid secret", key.Id, m.path)
+		}
+		m.keys[key.Id] = secret
+		kept = append(kept, key)
+	}
+	m.file.Keys = kept
+	if _, ok := m.keys[m.file.Active]; !ok {
+		return fmt.Errorf("active key %q is missing from %s", m.file.Active, m.path)
+	}
+	m.activeId = m.file.Active
+	return nil
+}
+
+// generate a new random key, make it the active key (retiring the old one) and save the key file
+func (m *keyManager) addKey() error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("error generating signing key: %v", err)
+	}
+	kid, err := newTokenId()
+	if err != nil {
+		return err
+	}
+	kid = kid[:12]
+
+	previous := keyFile{Active: m.file.Active, Keys: append([]signingKey{}, m.file.Keys...)}
+	now := time.Now()
+	for i := range m.file.Keys {
+		if m.file.Keys[i].Id == m.file.Active && m.file.Keys[i].Retired_At == nil {
+			m.file.Keys[i].Retired_At = &now
+		}
+	}
+	m.file.Keys = append(m.file.Keys, signingKey{Id: kid, Secret: hex.EncodeToString(secret), Created_At: now})
+	m.file.Active = kid
+	if err := m.useFile(); err != nil {
+		return err
+	}
+
+	//a key that couldn't be saved would be lost on restart along with every token it signed, so keep the old keys
+	if err := m.saveFile(); err != nil {
+		m.file = previous
+		if len(previous.Keys) > 0 {
+			m.useFile()
+		}
+		return err
+	}
+	return nil
+}
+
+// write the key file, readable only by the API's user
+func (m *keyManager) saveFile() error {
+	data, err := json.MarshalIndent(m.file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding key file: %v", err)
+	}
+	//written next to the old file and renamed over it, so a crash can't leave a half written key file
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing key file: %v", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("error saving key file: %v", err)
+	}
+	return nil
+}
+
+// make sure the keys are loaded, for callers that run before main.go loads them
+func (m *keyManager) ensureLoaded() error {
+	m.mu.RLock()
+	loaded := m.loaded
+	m.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.loaded {
+		return nil
+	}
+	return m.load()
+}
+
+// return the kid and secret new tokens are signed with
+func signingKeyForNewTokens() (string, []byte, error) {
+	if err := keys.ensureLoaded(); err != nil {
+		return "", nil, err
+	}
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	return keys.activeId, keys.keys[keys.activeId], nil
+}
+
+// given the kid from a token's header, return the secret to verify it with. Tokens from before key ids were
+// stamped have no kid and are verified with the active key.
+func verificationKey(kid string) ([]byte, error) {
+	if err := keys.ensureLoaded(); err != nil {
+		return nil, err
+	}
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	if kid == "" {
+		kid = keys.activeId
+	}
+	secret, ok := keys.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return secret, nil
+}
+
+// generate a new signing key and sign new tokens with it. The old key keeps verifying tokens until the last
+// refresh token it signed has expired, so nobody is logged out. Returns the new key's id.
+func RotateSigningKey() (string, error) {
+	if err := keys.ensureLoaded(); err != nil {
+		return "", err
+	}
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	if keys.fromEnv {
+		return "", ErrKeysFromEnv
+	}
+	if err := keys.addKey(); err != nil {
+		return "", err
+	}
+	log.Printf("Rotated JWT signing key, new key is %s", keys.activeId)
+	return keys.activeId, nil
+}
+
+// returns the id of the key new tokens are signed with and the ids of every key tokens are verified with
+func GetSigningKeyIds() (string, []string, error) {
+	if err := keys.ensureLoaded(); err != nil {
+		return "", nil, err
+	}
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	ids := []string{}
+	for id := range keys.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return keys.activeId, ids, nil
+}
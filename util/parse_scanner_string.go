@@ -2,16 +2,19 @@ package util
 
 import (
 	"fmt"
-	"strconv"
+	"log"
 	"strings"
 )
 
 type ParsedCardData struct {
-	Id       int
-	Username string
+	Format   string // name of the card format the scan was read as
+	KeyType  string // kind of credential the key is, see CardKeyNumber and CardKeyNfcUid
+	Key      string // the credential as read from the card: the card number, or the tag's UID
+	Username string // only set by formats that carry the cardholder's name
 }
 
-// return user Id (int) and Username (string) from the raw scanner output
+// return the credential key and Username (string) from the raw scanner output. The scan is read as the first
+// enabled card format (see CardSettings) that recognises it, scans no enabled format recognises are rejected.
 func ParseScannerString(scannerString string) (*ParsedCardData, error) {
 	if scannerString == "" || len(scannerString) >= 256 {
 		return nil, fmt.Errorf("invalid scanner string format")
@@ -19,16 +22,36 @@ func ParseScannerString(scannerString string) (*ParsedCardData, error) {
 
 	scannerString = strings.TrimSpace(scannerString)
 
+	for _, name := range enabledCardFormats() {
+		format, ok := cardFormats[name]
+		if !ok || !format.Detect(scannerString) {
+			continue
+		}
+		data, err := format.Parse(scannerString)
+		if err != nil {
+			log.Printf("Rejected %s card scan: %v", name, err)
+			return nil, fmt.Errorf("invalid %s card scan: %v", name, err)
+		}
+		data.Format = name
+		data.KeyType = format.KeyType
+		return data, nil
+	}
+
+	log.Printf("Rejected card scan: no enabled card format matches it (%d characters)", len(scannerString))
+	return nil, fmt.Errorf("invalid scanner string format")
+}
+
+// parse a magstripe Track 1 string: %B<id>^LAST/FIRST MIDDLE^...
+func parseTrack1(scannerString string) (*ParsedCardData, error) {
 	// Split by '^' to get the different fields
 	parts := strings.Split(scannerString, "^")
 	if len(parts) < 2 {
 		return nil, fmt.Errorf("invalid scanner string format")
 	}
 
-	id := strings.TrimPrefix(parts[0], "%B")
-	idNum, err := strconv.Atoi(id)
+	data, err := cardNumber(strings.TrimPrefix(parts[0], "%B"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse ID: %v", err)
+		return nil, err
 	}
 	username := strings.TrimSpace(parts[1])
 	username = strings.ReplaceAll(username, "/", " ")
@@ -45,8 +68,6 @@ func ParseScannerString(scannerString string) (*ParsedCardData, error) {
 		username = strings.Join(allParts, " ")
 	}
 
-	return &ParsedCardData{
-		Id:       idNum,
-		Username: username,
-	}, nil
+	data.Username = username
+	return data, nil
 }
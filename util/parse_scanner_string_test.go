@@ -0,0 +1,115 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseScannerString(t *testing.T) {
+	tests := []struct {
+		name    string
+		formats []string // card_formats setting, empty for every format
+		scan    string
+		want    *ParsedCardData // nil if the scan is rejected
+	}{
+		{
+			name: "track1",
+			scan: "%B6400130006318875^DOE/JOHN A^25121010000000000000?",
+			want: &ParsedCardData{Format: CardFormatTrack1, KeyType: CardKeyNumber, Key: "6400130006318875", Username: "JOHN A DOE"},
+		},
+		{
+			name: "track1 with leading zeros",
+			scan: "%B0012345^SMITH/JANE^",
+			want: &ParsedCardData{Format: CardFormatTrack1, KeyType: CardKeyNumber, Key: "12345", Username: "JANE SMITH"},
+		},
+		{name: "track1 without a name", scan: "%B6400130006318875", want: nil},
+		{name: "track1 with a short number", scan: "%B123^DOE/JOHN^", want: nil},
+		{
+			name: "track2",
+			scan: ";6400130006318875=25121010000000000?",
+			want: &ParsedCardData{Format: CardFormatTrack2, KeyType: CardKeyNumber, Key: "6400130006318875"},
+		},
+		{name: "track2 without an end sentinel", scan: ";6400130006318875=2512", want: nil},
+		{
+			name: "barcode",
+			scan: "6400130006318875",
+			want: &ParsedCardData{Format: CardFormatBarcode, KeyType: CardKeyNumber, Key: "6400130006318875"},
+		},
+		{
+			name: "barcode surrounded by whitespace",
+			scan: " 6400130006318875\n",
+			want: &ParsedCardData{Format: CardFormatBarcode, KeyType: CardKeyNumber, Key: "6400130006318875"},
+		},
+		{name: "barcode too long", scan: strings.Repeat("1", 21), want: nil},
+		{
+			name: "nfc uid with separators",
+			scan: "04:a2:3b:c4:d5:e6:f7",
+			want: &ParsedCardData{Format: CardFormatNfcUid, KeyType: CardKeyNfcUid, Key: "04A23BC4D5E6F7"},
+		},
+		{
+			name: "nfc uid of 4 bytes",
+			scan: "DEADBEEF",
+			want: &ParsedCardData{Format: CardFormatNfcUid, KeyType: CardKeyNfcUid, Key: "DEADBEEF"},
+		},
+		{name: "nfc uid of the wrong length", scan: "04A23B", want: nil},
+		{
+			name: "numeric nfc uid too long for a card number",
+			scan: "04123456789012345678",
+			want: &ParsedCardData{Format: CardFormatNfcUid, KeyType: CardKeyNfcUid, Key: "04123456789012345678"},
+		},
+		{
+			name: "numeric nfc uid with separators",
+			scan: "04:12:34:56:78:90:12",
+			want: &ParsedCardData{Format: CardFormatNfcUid, KeyType: CardKeyNfcUid, Key: "04123456789012"},
+		},
+		{
+			name: "digits that could be either read as a card number by default",
+			scan: "04123456",
+			want: &ParsedCardData{Format: CardFormatBarcode, KeyType: CardKeyNumber, Key: "4123456"},
+		},
+		{
+			name:    "digits that could be either read as an nfc uid when it is tried first",
+			formats: []string{CardFormatNfcUid, CardFormatBarcode},
+			scan:    "04123456",
+			want:    &ParsedCardData{Format: CardFormatNfcUid, KeyType: CardKeyNfcUid, Key: "04123456"},
+		},
+		{
+			name:    "card number that can't be a uid read as a barcode when nfc uid is tried first",
+			formats: []string{CardFormatNfcUid, CardFormatBarcode},
+			scan:    "6400130006318875",
+			want:    &ParsedCardData{Format: CardFormatBarcode, KeyType: CardKeyNumber, Key: "6400130006318875"},
+		},
+		{
+			name:    "digits read as an nfc uid when barcodes are off",
+			formats: []string{CardFormatNfcUid},
+			scan:    "12345678",
+			want:    &ParsedCardData{Format: CardFormatNfcUid, KeyType: CardKeyNfcUid, Key: "12345678"},
+		},
+		{name: "disabled format", formats: []string{CardFormatBarcode}, scan: ";6400130006318875=2512?", want: nil},
+		{name: "empty", scan: "", want: nil},
+		{name: "too long", scan: strings.Repeat("A", 256), want: nil},
+		{name: "not a card", scan: "hello there", want: nil},
+	}
+
+	saved := Settings.CardSettings.Formats
+	defer func() { Settings.CardSettings.Formats = saved }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Settings.CardSettings.Formats = tt.formats
+			got, err := ParseScannerString(tt.scan)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("expected the scan to be rejected, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
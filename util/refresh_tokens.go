@@ -0,0 +1,125 @@
+package util
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"gin-api/database"
+	"log"
+	"time"
+)
+
+// how long a refresh token can be used for. Every refresh issues a new one, so a session lasts as long as
+// it is refreshed at least this often.
+const refreshTokenLifetime = 7 * 24 * time.Hour
+
+// why a refresh token was revoked, stored in refresh_tokens.revoke_reason
+const (
+	RevokeLogout        = "logout"
+	RevokeReuseDetected = "reuse_detected"
+	RevokeAdmin         = "admin"
+	RevokeRoleChanged   = "role_changed"
+)
+
+// return a random id for a refresh token or a session
+func newTokenId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating token id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// given a new refresh token's id, its session, its user and when it expires, record it so it can be used once.
+// Records of tokens that have expired are cleared out, they can't be used either way.
+func storeRefreshToken(tokenId string, familyId string, userId int, expiresAt time.Time) error {
+	if _, err := database.DB.Exec("DELETE FROM refresh_tokens WHERE expires_at < ?", time.Now().Unix()); err != nil {
+		log.Printf("failed to clear expired refresh tokens: %v", err)
+	}
+	_, err := database.DB.Exec("INSERT INTO refresh_tokens (token_id, family_id, user_id, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		tokenId, familyId, userId, time.Now(), expiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("error storing refresh token: %v", err)
+	}
+	return nil
+}
+
+// given a refresh token's id, mark it used and return its session. A token that was already used means it was
+// copied, so every token in its session is revoked and ErrTokenReused is returned.
+func useRefreshToken(tokenId string) (string, error) {
+	var familyId string
+	var userId int
+	var used, revoked bool
+	err := database.DB.QueryRow("SELECT family_id, user_id, used_at IS NOT NULL, revoked_at IS NOT NULL FROM refresh_tokens WHERE token_id = ?", tokenId).Scan(
+		&familyId, &userId, &used, &revoked)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	} else if err != nil {
+		return "", fmt.Errorf("error getting refresh token: %v", err)
+	}
+	if revoked {
+		return "", ErrRevokedToken
+	}
+
+	// only one request can use the token, the loser of a race is treated the same as a reuse
+	if !used {
+		result, err := database.DB.Exec("UPDATE refresh_tokens SET used_at = ? WHERE token_id = ? AND used_at IS NULL AND revoked_at IS NULL", time.Now(), tokenId)
+		if err != nil {
+			return "", fmt.Errorf("error using refresh token: %v", err)
+		}
+		if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 1 {
+			return familyId, nil
+		}
+	}
+
+	if _, err := revokeRefreshTokens("family_id = ?", familyId, RevokeReuseDetected); err != nil {
+		return "", err
+	}
+	log.Printf("Refresh token reuse detected for user %d, session revoked", userId)
+	return "", ErrTokenReused
+}
+
+// given a refresh token, revoke the session it belongs to. Used to log out, so a token that is expired or
+// already used still ends its session.
+func RevokeRefreshSession(refreshToken string) error {
+	claims, err := parseRefreshToken(refreshToken)
+	if err != nil && err != ErrExpiredToken {
+		return err
+	}
+	if err == ErrExpiredToken {
+		return nil //nothing left to revoke, expired records are cleared out
+	}
+
+	var familyId string
+	err = database.DB.QueryRow("SELECT family_id FROM refresh_tokens WHERE token_id = ?", claims["jti"]).Scan(&familyId)
+	if err == sql.ErrNoRows {
+		return ErrInvalidToken
+	} else if err != nil {
+		return fmt.Errorf("error getting refresh token: %v", err)
+	}
+
+	_, err = revokeRefreshTokens("family_id = ?", familyId, RevokeLogout)
+	return err
+}
+
+// given a userId and why, revoke every session the user has. Returns the number of sessions that were still
+// active. Access tokens already issued keep working until they expire (at most 15 minutes).
+func RevokeUserRefreshTokens(userId int, reason string) (int, error) {
+	return revokeRefreshTokens("user_id = ?", userId, reason)
+}
+
+// given a condition on refresh_tokens and its argument, revoke every matching token that isn't revoked yet.
+// Returns the number of those tokens that were unused, which is one per active session.
+func revokeRefreshTokens(condition string, arg interface{}, reason string) (int, error) {
+	var active int
+	err := database.DB.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE "+condition+" AND used_at IS NULL AND revoked_at IS NULL", arg).Scan(&active)
+	if err != nil {
+		return 0, fmt.Errorf("error counting sessions: %v", err)
+	}
+	_, err = database.DB.Exec("UPDATE refresh_tokens SET revoked_at = ?, revoke_reason = ? WHERE "+condition+" AND revoked_at IS NULL", time.Now(), reason, arg)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking refresh tokens: %v", err)
+	}
+	return active, nil
+}
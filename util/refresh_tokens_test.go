@@ -0,0 +1,135 @@
+package util
+
+import (
+	"database/sql"
+	"errors"
+	"gin-api/database"
+	"gin-api/models"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// open a new database set up by EnsureSchema with user 1 in the user role, and sign tokens with a test key
+func setupTokenTest(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	previousDB := database.DB
+	database.SetDB(db)
+	t.Cleanup(func() {
+		database.SetDB(previousDB)
+		db.Close()
+		keys.mu.Lock()
+		keys.loaded = false
+		keys.mu.Unlock()
+	})
+
+	if err := database.EnsureSchema(); err != nil {
+		t.Fatalf("error setting up database: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO users (id, username, role_id) VALUES (1, 'test', (SELECT id FROM roles WHERE name = ?))", models.RoleUser)
+	if err != nil {
+		t.Fatalf("error adding user: %v", err)
+	}
+
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+	keys.mu.Lock()
+	keys.loaded = false
+	keys.mu.Unlock()
+}
+
+func TestRefreshAccessTokenRotates(t *testing.T) {
+	setupTokenTest(t)
+
+	pair, err := GenerateTokenPair(1, "user", []string{})
+	if err != nil {
+		t.Fatalf("error generating tokens: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		next, err := RefreshAccessToken(pair.RefreshToken)
+		if err != nil {
+			t.Fatalf("refresh %d failed: %v", i+1, err)
+		}
+		if next.RefreshToken == pair.RefreshToken {
+			t.Fatalf("refresh %d returned the same refresh token", i+1)
+		}
+		pair = next
+	}
+
+	var tokens, unused, families int
+	err = database.DB.QueryRow("SELECT COUNT(*), COUNT(*) - COUNT(used_at), COUNT(DISTINCT family_id) FROM refresh_tokens").Scan(&tokens, &unused, &families)
+	if err != nil {
+		t.Fatalf("error counting tokens: %v", err)
+	}
+	if tokens != 4 || unused != 1 || families != 1 {
+		t.Errorf("got %d tokens (%d unused) in %d sessions, want 4 tokens (1 unused) in 1 session", tokens, unused, families)
+	}
+}
+
+func TestRefreshAccessTokenReuse(t *testing.T) {
+	setupTokenTest(t)
+
+	first, err := GenerateTokenPair(1, "user", []string{})
+	if err != nil {
+		t.Fatalf("error generating tokens: %v", err)
+	}
+	other, err := GenerateTokenPair(1, "user", []string{})
+	if err != nil {
+		t.Fatalf("error generating tokens: %v", err)
+	}
+	second, err := RefreshAccessToken(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("first refresh failed: %v", err)
+	}
+
+	// presenting a used token again revokes the whole session
+	if _, err := RefreshAccessToken(first.RefreshToken); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("reusing a refresh token: got %v, want ErrTokenReused", err)
+	}
+	if _, err := RefreshAccessToken(second.RefreshToken); !errors.Is(err, ErrRevokedToken) {
+		t.Fatalf("refreshing after reuse: got %v, want ErrRevokedToken", err)
+	}
+
+	var reason string
+	if err := database.DB.QueryRow("SELECT revoke_reason FROM refresh_tokens WHERE revoked_at IS NOT NULL LIMIT 1").Scan(&reason); err != nil {
+		t.Fatalf("error getting revoke reason: %v", err)
+	}
+	if reason != RevokeReuseDetected {
+		t.Errorf("got revoke reason %q, want %q", reason, RevokeReuseDetected)
+	}
+
+	// the user's other session is left alone
+	if _, err := RefreshAccessToken(other.RefreshToken); err != nil {
+		t.Errorf("refreshing another session failed: %v", err)
+	}
+}
+
+func TestRevokeUserRefreshTokens(t *testing.T) {
+	setupTokenTest(t)
+
+	var pairs []*TokenPair
+	for i := 0; i < 2; i++ {
+		pair, err := GenerateTokenPair(1, "user", []string{})
+		if err != nil {
+			t.Fatalf("error generating tokens: %v", err)
+		}
+		pairs = append(pairs, pair)
+	}
+
+	active, err := RevokeUserRefreshTokens(1, RevokeRoleChanged)
+	if err != nil {
+		t.Fatalf("error revoking sessions: %v", err)
+	}
+	if active != 2 {
+		t.Errorf("got %d active sessions revoked, want 2", active)
+	}
+	for i, pair := range pairs {
+		if _, err := RefreshAccessToken(pair.RefreshToken); !errors.Is(err, ErrRevokedToken) {
+			t.Errorf("session %d: got %v, want ErrRevokedToken", i+1, err)
+		}
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"gin-api/database"
 	"gin-api/models"
+	"log"
+	"time"
 )
 
+// timezone used when the settings row doesn't name a valid one
+const defaultLabTimezone = "America/New_York"
+
 // Global variable referenced by other packages to get/set the settings.
 // faster than fetching from db every time
 var Settings models.Settings
@@ -15,8 +20,10 @@ func ImportSettingsFromDB() error {
 	querySQL := `SELECT day_max_print_hours_week, night_max_print_hours_week,
 						day_max_print_hours_weekend, night_max_print_hours_weekend,
 						day_start, night_start, default_user_weekly_hours,
-						max_active_reservations
+						timezone, max_active_reservations, check_in_minutes,
+						reset_weekday, reset_time, rollover_cap_minutes, card_formats
 						FROM settings WHERE name = "default"`
+	var cardFormats string
 	err := database.DB.QueryRow(querySQL).Scan(
 		&Settings.TimeSettings.WeekdayPrintTime.DayMaxPrintHours,
 		&Settings.TimeSettings.WeekdayPrintTime.NightMaxPrintHours,
@@ -25,10 +32,17 @@ func ImportSettingsFromDB() error {
 		&Settings.TimeSettings.DayStart,
 		&Settings.TimeSettings.NightStart,
 		&Settings.TimeSettings.DefaultUserWeeklyHours,
-		&Settings.PrinterSettings.MaxActiveReservations)
+		&Settings.TimeSettings.Timezone,
+		&Settings.PrinterSettings.MaxActiveReservations,
+		&Settings.PrinterSettings.CheckInMinutes,
+		&Settings.ResetSettings.ResetWeekday,
+		&Settings.ResetSettings.ResetTime,
+		&Settings.ResetSettings.RolloverCapMinutes,
+		&cardFormats)
 	if err != nil {
 		return fmt.Errorf("error getting settings from db: %v", err)
 	}
+	Settings.CardSettings.Formats = SplitCardFormats(cardFormats)
 
 	ToggleUpToDateAll(true)
 	return nil
@@ -38,4 +52,22 @@ func ImportSettingsFromDB() error {
 func ToggleUpToDateAll(state bool) {
 	Settings.PrinterSettings.UpToDate = state
 	Settings.TimeSettings.UpToDate = state
+	Settings.ResetSettings.UpToDate = state
+	Settings.CardSettings.UpToDate = state
+}
+
+//returns the lab's timezone from the time settings, falling back to the default lab timezone if it is unset or invalid
+func LabLocation() *time.Location {
+	name := Settings.TimeSettings.Timezone
+	if name == "" {
+		name = defaultLabTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("invalid lab timezone %q, falling back to %s: %v", name, defaultLabTimezone, err)
+		if loc, err = time.LoadLocation(defaultLabTimezone); err != nil {
+			return time.Local
+		}
+	}
+	return loc
 }